@@ -43,19 +43,37 @@ type yamlSpec struct {
 	Name          string
 	Version       string
 	Description   string
-	Console       *bool
-	Gui           bool
+	Console       *consoleSpec
+	Gui           guiMode
+	Audio         bool           `yaml:",omitempty"`
+	Dbus          dbusSpec       `yaml:",omitempty"`
+	Forward       []string       `yaml:",omitempty"`
+	Secrets       []secretSpec   `yaml:",omitempty"`
+	Security      *securitySpec  `yaml:",omitempty"`
+	Resources     *resourcesSpec `yaml:",omitempty"`
+	Icon          string         `yaml:",omitempty"`
 
 	Image struct {
 		Base       string
 		Dockerfile string
+		Stages     []stageSpec       `yaml:",omitempty"`
+		BuildArgs  map[string]string `yaml:"buildArgs,omitempty"`
+		Network    string            `yaml:",omitempty"`
+		Syntax     string            `yaml:",omitempty"`
 		Storage    struct {
 			Driver string
 		}
 	}
 	Runtime struct {
-		Driver string
-		Docker struct {
+		Driver      string
+		Mode        string   `yaml:",omitempty"`
+		IdleTimeout string   `yaml:"idleTimeout,omitempty"`
+		Init        *bool    `yaml:",omitempty"`
+		StopTimeout string   `yaml:"stopTimeout,omitempty"`
+		Network     string   `yaml:",omitempty"`
+		Ports       []string `yaml:",omitempty"`
+		ExtraHosts  []string `yaml:"extraHosts,omitempty"`
+		Docker      struct {
 			RunArgs []string
 		}
 	}
@@ -63,11 +81,26 @@ type yamlSpec struct {
 
 // AppInfo represents an application config file
 type AppInfo struct {
-	appConfig      yamlSpec
-	env            environment
-	resolvedParams map[string]string
+	appConfig         yamlSpec
+	env               environment
+	resolvedParams    map[string]string
+	console           consoleOverrides
+	strictSecurity    bool
+	extraRunArgs      []string
+	resourceOverrides resourcesSpec
+	buildKit          bool
+	builtinMounts     bool
+	buildArgOverrides map[string]string
+	cleanupFuncs      []func()
 }
 
+// short options of docker run without value which can be combined with other short options
+const shortBoolRunOptions = "dPit"
+
+// names of Docker volumes, the source of a volume is a host path otherwise
+var namedVolumeRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+var volumeOptionsRegex = regexp.MustCompile(`^(ro|rw|z|Z|r?shared|r?slave|r?private|nocopy|cached|delegated|consistent)(,(ro|rw|z|Z|r?shared|r?slave|r?private|nocopy|cached|delegated|consistent))*$`)
 var parameterRegex = regexp.MustCompile("\\$\\{[[:word:]]+(\\(.*?\\))?\\}")
 var parameterSplitRegex = regexp.MustCompile(`(?P<name>[[:word:]]+)(\((?P<args>.+)\))?`)
 
@@ -106,6 +139,16 @@ func NewFakeAppInfo(fs *afero.Fs, appConfigFile string, appConfigStr string) *Ap
 		return name
 	}
 
+	// mock xauth (no X server)
+	runXauth = func(args ...string) (string, error) {
+		return "", nil
+	}
+
+	// mock terminal (interactive session)
+	isTerminal = func(file *os.File) bool {
+		return true
+	}
+
 	// mock environment
 	getEnv = func(appConfigFile string) environment {
 		absAppConfigFile, err := filepath.Abs(appConfigFile)
@@ -143,6 +186,16 @@ func validate(appInfoConfig yamlSpec) {
 			logFatalf("App file is not compatible with current containerflight version %s!", cfVersion.String())
 		}
 	}
+
+	validateForwards(appInfoConfig.Forward)
+	validateSecrets(appInfoConfig.Secrets)
+	validateSecurity(appInfoConfig.Security)
+	validateNetwork(appInfoConfig)
+	validateResources(appInfoConfig.Resources)
+	validateBuildArgs(appInfoConfig.Image.BuildArgs)
+	validateDockerfileSyntax(appInfoConfig.Image.Syntax)
+	validateStages(appInfoConfig)
+	validateRuntimeMode(appInfoConfig)
 }
 
 // read and parse app config file
@@ -229,10 +282,7 @@ func (cfg *AppInfo) replaceParameters(str *string) {
 							args[i] = strings.TrimSpace(args[i])
 						}
 						if len(split) >= 1 {
-							return "RUN apt-get update && \\\n" +
-								"    export DEBIAN_FRONTEND=noninteractive && \\\n" +
-								"    apt-get install -y " + strings.Join(args, " ") + " && \\\n" +
-								"    rm -rf /var/lib/apt/lists/*"
+							return cfg.getAptInstall(args)
 						}
 					}
 				case "ADD":
@@ -263,10 +313,14 @@ func (cfg *AppInfo) replaceParameters(str *string) {
 	}
 }
 
-// GetResolvedAppConfig returns the resolved app file
+// GetResolvedAppConfig returns the resolved app file (without secrets and build args)
 func (cfg *AppInfo) GetResolvedAppConfig() string {
 
-	appConfigByte, err := yaml.Marshal(&cfg.appConfig)
+	appConfig := cfg.appConfig
+	appConfig.Secrets = nil
+	appConfig.Image.BuildArgs = nil
+
+	appConfigByte, err := yaml.Marshal(&appConfig)
 	util.CheckErr(err)
 
 	appConfigStr := string(appConfigByte)
@@ -321,24 +375,78 @@ func (cfg *AppInfo) GetAppDescription() string {
 	return description
 }
 
-// IsConsoleApp returns true if a TTY should be allocated and stdin should be opened (default behavior)
-func (cfg *AppInfo) IsConsoleApp() bool {
-	return cfg.appConfig.Console == nil || *cfg.appConfig.Console
+// GetAppIcon returns the icon of an application (file name or name of a theme icon)
+func (cfg *AppInfo) GetAppIcon() string {
+	icon := cfg.appConfig.Icon
+
+	// replace parameters
+	cfg.replaceParameters(&icon)
+
+	// relative paths are relative to the app file
+	if strings.ContainsAny(icon, `/\`) && !filepath.IsAbs(icon) {
+		icon = filepath.Join(cfg.env.appFileDir, icon)
+	}
+
+	return icon
+}
+
+// IsGuiApp returns true if the application needs access to the display server of the host
+func (cfg *AppInfo) IsGuiApp() bool {
+	return cfg.appConfig.Gui != guiNone
+}
+
+// AddDockerRunArgs appends docker run arguments to the runargs of the app file
+func (cfg *AppInfo) AddDockerRunArgs(runArgs ...string) {
+	checkNetworkRunArgs(getRuntimeNetwork(cfg.appConfig), runArgs)
+	cfg.extraRunArgs = append(cfg.extraRunArgs, runArgs...)
+}
+
+// IsDetached returns true if the runargs of the app file or the command line detach the app container
+func (cfg *AppInfo) IsDetached() bool {
+	runArgs := append(append([]string{}, cfg.appConfig.Runtime.Docker.RunArgs...), cfg.extraRunArgs...)
+	detached := false
+	for _, runArg := range runArgs {
+		switch runArg = strings.TrimSpace(runArg); runArg {
+		case "--detach", "--detach=true":
+			detached = true
+		case "--detach=false":
+			detached = false
+		default:
+			detached = detached || hasShortRunOption(runArg, 'd')
+		}
+	}
+	return detached
+}
+
+// return true if a docker run argument contains a short option, short options without value can be
+// combined ("-dit") and the value of an option can be attached ("-p8080:80")
+func hasShortRunOption(runArg string, option rune) bool {
+	if !strings.HasPrefix(runArg, "-") || strings.HasPrefix(runArg, "--") {
+		return false
+	}
+	for _, shortOption := range runArg[1:] {
+		if shortOption == option {
+			return true
+		}
+		if !strings.ContainsRune(shortBoolRunOptions, shortOption) {
+			// the rest of the argument is the value of the option
+			return false
+		}
+	}
+	return false
 }
 
 // GetDockerfile returns for an app file the resolved dockerfile
 func (cfg *AppInfo) GetDockerfile() string {
 	dockerfileFinal := ""
-	re := regexp.MustCompile("^docker://")
-	baseImage := re.ReplaceAllString(cfg.appConfig.Image.Base, "FROM ")
-	if baseImage != "" {
-		dockerfileFinal += baseImage + "\n\n"
+	for i, stage := range cfg.getImageStages() {
+		if i > 0 {
+			dockerfileFinal += "\n"
+		}
+		dockerfileFinal += cfg.getStageDockerfile(stage)
 	}
 
-	dockerfile := cfg.handleDockerfileLoad(cfg.appConfig.Image.Dockerfile)
-
-	dockerfileFinal += cfg.resolvedParams["SET_PROXY"] + "\n" + dockerfile
-	// no user mapping required on windows
+	// user mapping is only needed in the app image, no user mapping required on windows
 	if runtime.GOOS != "windows" {
 		dockerfileFinal += "\n" + cfg.resolvedParams["USER_CTX"]
 	}
@@ -346,6 +454,9 @@ func (cfg *AppInfo) GetDockerfile() string {
 	// replace parameters
 	cfg.replaceParameters(&dockerfileFinal)
 
+	// the frontend depends on the instructions which are generated by the macros
+	dockerfileFinal = cfg.getDockerfileSyntax(dockerfileFinal) + dockerfileFinal
+
 	log.Debug("dockerfile: ", dockerfileFinal)
 
 	return dockerfileFinal
@@ -378,10 +489,11 @@ func (cfg *AppInfo) handleDockerfileLoad(dockerfile string) string {
 func (cfg *AppInfo) GetDockerRunArgs() (dockerRunArgs []string) {
 	unixWorkingDir := util.GetUnixFilePath(util.GetWorkingDir())
 	defaultDockerArgs := map[string]string{
-		"-h": "flybydocker",
+		"-h": defaultHostname,
 		"-w": unixWorkingDir,
 	}
-	for _, arg := range cfg.appConfig.Runtime.Docker.RunArgs {
+	runArgs := append(append([]string{}, cfg.appConfig.Runtime.Docker.RunArgs...), cfg.extraRunArgs...)
+	for _, arg := range runArgs {
 		if _, ok := defaultDockerArgs[arg]; ok {
 			delete(defaultDockerArgs, arg)
 		}
@@ -389,25 +501,18 @@ func (cfg *AppInfo) GetDockerRunArgs() (dockerRunArgs []string) {
 
 	dockerRunArgs = append(
 		[]string{"-v", cfg.env.workingDir + ":" + unixWorkingDir},
-		cfg.appConfig.Runtime.Docker.RunArgs...,
+		runArgs...,
 	)
 
-	if cfg.IsConsoleApp() {
-		fi, _ := os.Stdin.Stat()
-		if (fi.Mode() & os.ModeCharDevice) == 0 {
-			// input from pipe
-			dockerRunArgs = append(dockerRunArgs, "-i")
-		} else {
-			dockerRunArgs = append(dockerRunArgs, "-ti")
-		}
-	}
-
-	if cfg.appConfig.Gui {
-		dockerRunArgs = append(dockerRunArgs,
-			"-e", "DISPLAY="+getEnvVar("DISPLAY"),
-			"-v", "/tmp/.X11-unix:/tmp/.X11-unix",
-		)
-	}
+	dockerRunArgs = append(dockerRunArgs, cfg.GetConsoleArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getGuiRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getAudioRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getDbusRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getForwardRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getSecurityRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getNetworkRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getResourcesRunArgs()...)
+	dockerRunArgs = append(dockerRunArgs, cfg.getStopTimeoutRunArgs()...)
 
 	// take default values of unset Docker arguments
 	keys := make([]string, 0)
@@ -425,15 +530,23 @@ func (cfg *AppInfo) GetDockerRunArgs() (dockerRunArgs []string) {
 		if strings.TrimSpace(dockerRunArgs[i]) == "-v" && i+1 < len(dockerRunArgs) {
 			// first split by ":" and then replace parameters due to windows drive letters
 			dirs := strings.Split(dockerRunArgs[i+1], ":")
+			options := ""
+			if len(dirs) == 3 && volumeOptionsRegex.MatchString(dirs[2]) {
+				options = ":" + dirs[2]
+				dirs = dirs[:2]
+			}
 			if len(dirs) == 2 {
 				hostPathTmp := strings.TrimPrefix(strings.TrimSuffix(dirs[0], `"`), `"`)
 				cfg.replaceParameters(&hostPathTmp)
-				hostPath, _ := filepath.Abs(filepath.FromSlash(filepath.ToSlash(hostPathTmp)))
+				hostPath := hostPathTmp
+				if !namedVolumeRegex.MatchString(hostPath) {
+					hostPath, _ = filepath.Abs(filepath.FromSlash(filepath.ToSlash(hostPathTmp)))
+				}
 				containerPathTmp := strings.TrimPrefix(strings.TrimSuffix(dirs[1], `"`), `"`)
 				cfg.replaceParameters(&containerPathTmp)
 				containerPath := path.Clean(util.GetUnixFilePath(containerPathTmp))
 				i++
-				dockerRunArgs[i] = hostPath + ":" + containerPath
+				dockerRunArgs[i] = hostPath + ":" + containerPath + options
 			}
 		} else {
 			cfg.replaceParameters(&dockerRunArgs[i])
@@ -445,6 +558,26 @@ func (cfg *AppInfo) GetDockerRunArgs() (dockerRunArgs []string) {
 	return dockerRunArgs
 }
 
+// Cleanup removes temporary files etc. which have been created for running an app
+func (cfg *AppInfo) Cleanup() {
+	// in reverse order, e.g. a process is stopped before its directory is removed
+	for i := len(cfg.cleanupFuncs) - 1; i >= 0; i-- {
+		cfg.cleanupFuncs[i]()
+	}
+	cfg.cleanupFuncs = nil
+}
+
+// NeedsTemporaryResources returns true if a run of the app depends on temporary files or processes
+// (an Xauthority file of the X server or a D-Bus proxy) which are removed by Cleanup()
+func (cfg *AppInfo) NeedsTemporaryResources() bool {
+	return cfg.usesX11() || cfg.usesDbusProxy()
+}
+
+// register a function which is called by Cleanup()
+func (cfg *AppInfo) addCleanup(cleanupFunc func()) {
+	cfg.cleanupFuncs = append(cfg.cleanupFuncs, cleanupFunc)
+}
+
 var getEnvVar = func(name string) string {
 	return os.Getenv(name)
 }
@@ -16,7 +16,11 @@ package appinfo
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	yaml "github.com/go-yaml/yaml"
 	"github.com/spf13/afero"
@@ -128,6 +132,20 @@ func TestAppDescription(t *testing.T) {
 
 // ---
 
+func TestAppIcon(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/apps/testAppFile", "icon: icons/app.png")
+
+	assert.Equal(t, "/apps/icons/app.png", appInfo.GetAppIcon())
+}
+
+func TestAppIconThemeName(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "icon: utilities-terminal")
+
+	assert.Equal(t, "utilities-terminal", appInfo.GetAppIcon())
+}
+
+// ---
+
 func TestIsConsoleAppNotSet(t *testing.T) {
 	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "")
 
@@ -146,6 +164,55 @@ func TestIsConsoleAppSetTrue(t *testing.T) {
 	assert.Equal(t, true, appInfo.IsConsoleApp())
 }
 
+func TestIsConsoleAppDisabled(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "console: true")
+	appInfo.DisableConsole()
+
+	assert.Equal(t, false, appInfo.IsConsoleApp())
+}
+
+func TestConsoleArgsOutputRedirected(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	origIsTerminal := isTerminal
+	defer func() { isTerminal = origIsTerminal }()
+
+	// stdout is a file
+	isTerminal = func(file *os.File) bool {
+		return file != os.Stdout
+	}
+	assert.Equal(t, []string{"-i"}, appInfo.GetConsoleArgs())
+
+	// explicit TTY
+	appInfo.SetTTY(true)
+	assert.Equal(t, []string{"-ti"}, appInfo.GetConsoleArgs())
+}
+
+func TestConsoleArgsAppFile(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "console:\n    tty: false")
+	assert.Equal(t, []string{"-i"}, appInfo.GetConsoleArgs())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "console:\n    interactive: false")
+	assert.Equal(t, []string{"-t"}, appInfo.GetConsoleArgs())
+
+	// command line options take precedence
+	appInfo.SetInteractive(true)
+	assert.Equal(t, []string{"-ti"}, appInfo.GetConsoleArgs())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	appInfo.SetInteractive(true)
+	assert.Equal(t, []string{"-i"}, appInfo.GetConsoleArgs())
+	assert.False(t, appInfo.HasTTY())
+	assert.True(t, appInfo.IsInteractive())
+}
+
+func TestConsoleHashStable(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	assert.Regexp(t, "(?m)^console: false$", appInfo.GetResolvedAppConfig())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	assert.Regexp(t, "(?m)^console: null$", appInfo.GetResolvedAppConfig())
+}
+
 // ---
 
 func TestDockerfileBasic(t *testing.T) {
@@ -174,6 +241,50 @@ func TestDockerfileBasic(t *testing.T) {
 	assert.Equal(t, expDockerfile, appInfo.GetDockerfile())
 }
 
+func TestDockerfileStages(t *testing.T) {
+
+	appConfigStr :=
+		"image:\n" +
+			"    stages:\n" +
+			"        - name: builder\n" +
+			"          base: docker://golang:1.14\n" +
+			"          dockerfile: |\n" +
+			"              RUN go build\n" +
+			"        - base: docker://ubuntu:18.04\n" +
+			"          dockerfile: |\n" +
+			"              COPY --from=builder /app /app\n"
+
+	expDockerfile := "FROM golang:1.14 AS builder\n\n" +
+		"ENV http_proxy=http_proxy\n" +
+		"ENV https_proxy=https_proxy\n" +
+		"ENV no_proxy=no_proxy\n" +
+		"\n" +
+		"RUN go build\n" +
+		"\n" +
+		"FROM ubuntu:18.04\n\n" +
+		fmt.Sprintf(dockerFileTmpl, "COPY --from=builder /app /app\n")
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerfile, appInfo.GetDockerfile())
+
+	// USER_CTX only in the final stage
+	assert.Equal(t, 1, strings.Count(appInfo.GetDockerfile(), "USER testuser"))
+}
+
+func TestStagesInvalid(t *testing.T) {
+	appConfigStrs := []string{
+		"image:\n    base: docker://ubuntu\n    stages:\n        - base: docker://ubuntu",
+		"image:\n    stages:\n        - name: builder",
+		"image:\n    stages:\n        - name: 1builder\n          base: docker://golang",
+		"image:\n    stages:\n        - name: builder\n          base: docker://golang\n        - name: Builder\n          base: docker://ubuntu",
+	}
+	for _, appConfigStr := range appConfigStrs {
+		testForLogFatal(t, func() {
+			NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+		})
+	}
+}
+
 func TestDockerfileApt(t *testing.T) {
 
 	appConfigStr :=
@@ -191,6 +302,53 @@ func TestDockerfileApt(t *testing.T) {
 	assert.Equal(t, expDockerfile, appInfo.GetDockerfile())
 }
 
+func TestDockerfileAptInstallBuildKit(t *testing.T) {
+	appConfigStr :=
+		"image:\n" +
+			"    dockerfile: |\n" +
+			"        ${APT_INSTALL(pkg1, pkg2)}\n"
+
+	expDockerfile := "# syntax = docker/dockerfile:1.2\n" + fmt.Sprintf(dockerFileTmpl,
+		"RUN --mount=type=cache,target=/var/cache/apt,sharing=locked \\\n"+
+			"    --mount=type=cache,target=/var/lib/apt/lists,sharing=locked \\\n"+
+			"    rm -f /etc/apt/apt.conf.d/docker-clean && \\\n"+
+			"    apt-get update && \\\n"+
+			"    export DEBIAN_FRONTEND=noninteractive && \\\n"+
+			"    apt-get install -y pkg1 pkg2\n")
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	appInfo.EnableBuildKit(false)
+	assert.Equal(t, expDockerfile, appInfo.GetDockerfile())
+
+	// the built-in frontend of the daemon supports cache mounts
+	appInfo.EnableBuildKit(true)
+	assert.Equal(t, expDockerfile[len("# syntax = docker/dockerfile:1.2\n"):], appInfo.GetDockerfile())
+}
+
+func TestDockerfileSyntax(t *testing.T) {
+	// no external frontend without "RUN --mount"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "image:\n    dockerfile: RUN test")
+	appInfo.EnableBuildKit(false)
+	assert.NotRegexp(t, "# syntax", appInfo.GetDockerfile())
+
+	appConfigStr := "image:\n    syntax: %s\n    dockerfile: |\n        ${APT_INSTALL(pkg1)}\n"
+
+	// opt out of the external frontend
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", fmt.Sprintf(appConfigStr, "builtin"))
+	appInfo.EnableBuildKit(false)
+	assert.NotRegexp(t, "# syntax", appInfo.GetDockerfile())
+	assert.Regexp(t, "RUN --mount=type=cache", appInfo.GetDockerfile())
+
+	// frontend of a local registry
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", fmt.Sprintf(appConfigStr, "registry.local/dockerfile:1.2"))
+	appInfo.EnableBuildKit(true)
+	assert.Regexp(t, "^# syntax = registry.local/dockerfile:1.2\n", appInfo.GetDockerfile())
+
+	testForLogFatal(t, func() {
+		NewFakeAppInfo(&filesystem, "/testAppFile", fmt.Sprintf(appConfigStr, "'docker/dockerfile 1.2'"))
+	})
+}
+
 func TestDockerfileAdd(t *testing.T) {
 
 	filesystem.Mkdir("/foo", 0755)
@@ -317,6 +475,569 @@ func TestDockerRunArgsGui(t *testing.T) {
 	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
 }
 
+func TestDockerRunArgsGuiX11(t *testing.T) {
+
+	appConfigStr := "gui: x11"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti", "-e", "DISPLAY=DISPLAY", "-v", "/tmp/.X11-unix:/tmp/.X11-unix", "-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsGuiXauthority(t *testing.T) {
+	appConfigStr :=
+		"gui: x11\n" +
+			"runtime:\n" +
+			"    docker:\n" +
+			"        runargs: [\"-h\", \"myhostname\"]"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	defer mockEnvVars(map[string]string{"DISPLAY": ":1.0"})()
+
+	xauthCalls := [][]string{}
+	runXauth = func(args ...string) (string, error) {
+		xauthCalls = append(xauthCalls, args)
+		return "myhost/unix:1  MIT-MAGIC-COOKIE-1  0123abcd\n", nil
+	}
+
+	dockerRunArgs := appInfo.GetDockerRunArgs()
+
+	xauthority := xauthCalls[1][1]
+	assert.Equal(t, [][]string{
+		{"list", ":1.0"},
+		{"-f", xauthority, "add", "myhostname/unix:1", "MIT-MAGIC-COOKIE-1", "0123abcd"},
+	}, xauthCalls)
+	assert.Equal(t, []string{"-v", "/myworkingdir:/myworkingdir", "-h", "myhostname", "-ti",
+		"-e", "DISPLAY=:1.0", "-v", "/tmp/.X11-unix:/tmp/.X11-unix",
+		"-e", "XAUTHORITY=/tmp/.containerflight.xauth", "-v", xauthority + ":/tmp/.containerflight.xauth:ro",
+		"-w", "/myworkingdir"}, dockerRunArgs)
+
+	// the Xauthority file is removed after the run
+	_, err := filesystem.Stat(xauthority)
+	assert.NoError(t, err)
+	appInfo.Cleanup()
+	_, err = filesystem.Stat(xauthority)
+	assert.Error(t, err)
+}
+
+func TestDockerRunArgsGuiWayland(t *testing.T) {
+	filesystem.MkdirAll("/run/user/1234", 0700)
+	afero.WriteFile(filesystem, "/run/user/1234/wayland-0", []byte{}, 0600)
+
+	for _, guiMode := range []string{"true", "wayland"} {
+		appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "gui: "+guiMode)
+		defer mockEnvVars(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1234", "WAYLAND_DISPLAY": "wayland-0"})()
+
+		expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+			"-e", "XDG_RUNTIME_DIR=/run/user/1234", "-e", "WAYLAND_DISPLAY=wayland-0", "-v", "/run/user/1234/wayland-0:/run/user/1234/wayland-0",
+			"-h", "flybydocker", "-w", "/myworkingdir"}
+
+		assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+	}
+}
+
+func TestDockerRunArgsGuiWaylandAbsolute(t *testing.T) {
+	filesystem.MkdirAll("/run/wayland", 0700)
+	afero.WriteFile(filesystem, "/run/wayland/wayland-0", []byte{}, 0600)
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "gui: wayland")
+	defer mockEnvVars(map[string]string{"WAYLAND_DISPLAY": "/run/wayland/wayland-0"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "WAYLAND_DISPLAY=/run/wayland/wayland-0", "-v", "/run/wayland/wayland-0:/run/wayland/wayland-0",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsGuiWaylandWithXWayland(t *testing.T) {
+	filesystem.MkdirAll("/run/user/1234", 0700)
+	afero.WriteFile(filesystem, "/run/user/1234/wayland-0", []byte{}, 0600)
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "gui: auto")
+	defer mockEnvVars(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1234", "WAYLAND_DISPLAY": "wayland-0", "DISPLAY": ":0"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "XDG_RUNTIME_DIR=/run/user/1234", "-e", "WAYLAND_DISPLAY=wayland-0", "-v", "/run/user/1234/wayland-0:/run/user/1234/wayland-0",
+		"-e", "DISPLAY=:0", "-v", "/tmp/.X11-unix:/tmp/.X11-unix",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsGuiWaylandMissing(t *testing.T) {
+	testForLogFatal(t, func() {
+		appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "gui: wayland")
+		defer mockEnvVars(map[string]string{})()
+		appInfo.GetDockerRunArgs()
+	})
+}
+
+func TestDockerRunArgsAudio(t *testing.T) {
+	filesystem.MkdirAll("/run/user/1234/pulse", 0700)
+	afero.WriteFile(filesystem, "/run/user/1234/pulse/native", []byte{}, 0600)
+	afero.WriteFile(filesystem, "/run/user/1234/pipewire-0", []byte{}, 0600)
+	filesystem.MkdirAll("/home/.config/pulse", 0700)
+	afero.WriteFile(filesystem, "/home/.config/pulse/cookie", []byte("cookie"), 0600)
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "audio: true")
+	defer mockEnvVars(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1234"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "PULSE_SERVER=unix:/run/user/1234/pulse/native", "-v", "/run/user/1234/pulse/native:/run/user/1234/pulse/native",
+		"-e", "PULSE_COOKIE=/tmp/.containerflight.pulse-cookie", "-v", "/home/.config/pulse/cookie:/tmp/.containerflight.pulse-cookie:ro",
+		"-e", "PIPEWIRE_RUNTIME_DIR=/run/user/1234", "-v", "/run/user/1234/pipewire-0:/run/user/1234/pipewire-0",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsAudioNoServer(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "audio: true")
+	defer mockEnvVars(map[string]string{"XDG_RUNTIME_DIR": "/run/user/none"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti", "-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsDbusSession(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "dbus: session")
+	defer mockEnvVars(map[string]string{"DBUS_SESSION_BUS_ADDRESS": "unix:path=/run/user/1234/bus,guid=123"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1234/bus", "-v", "/run/user/1234/bus:/run/user/1234/bus",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsDbusSystem(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "dbus: system")
+	defer mockEnvVars(map[string]string{})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "DBUS_SYSTEM_BUS_ADDRESS=unix:path=/run/dbus/system_bus_socket", "-v", "/run/dbus/system_bus_socket:/run/dbus/system_bus_socket",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsDbusProxy(t *testing.T) {
+	appConfigStr :=
+		"dbus:\n" +
+			"    bus: session\n" +
+			"    talk: [org.freedesktop.Notifications]\n"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	defer mockEnvVars(map[string]string{"XDG_RUNTIME_DIR": "/run/user/1234"})()
+
+	proxyStopped := false
+	origStartDbusProxy := startDbusProxy
+	defer func() { startDbusProxy = origStartDbusProxy }()
+	proxyArgs := []string{}
+	startDbusProxy = func(busAddress string, socket string, talk []string) (func(), error) {
+		proxyArgs = append([]string{busAddress, socket}, talk...)
+		return func() {
+			// the socket directory is removed after the proxy has been stopped
+			_, err := filesystem.Stat(filepath.Dir(socket))
+			proxyStopped = err == nil
+		}, nil
+	}
+
+	assert.True(t, appInfo.NeedsTemporaryResources())
+	dockerRunArgs := appInfo.GetDockerRunArgs()
+
+	proxySocket := proxyArgs[1]
+	assert.Equal(t, []string{"unix:path=/run/user/1234/bus", proxySocket, "org.freedesktop.Notifications"}, proxyArgs)
+	assert.Equal(t, []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "DBUS_SESSION_BUS_ADDRESS=unix:path=/tmp/.containerflight.dbus", "-v", proxySocket + ":/tmp/.containerflight.dbus",
+		"-h", "flybydocker", "-w", "/myworkingdir"}, dockerRunArgs)
+
+	appInfo.Cleanup()
+	assert.Equal(t, true, proxyStopped)
+	_, err := filesystem.Stat(filepath.Dir(proxySocket))
+	assert.Error(t, err)
+}
+
+func TestDbusResolvedAppConfig(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "dbus: session")
+	assert.Regexp(t, "(?m)^dbus: session$", appInfo.GetResolvedAppConfig())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	assert.NotRegexp(t, "dbus", appInfo.GetResolvedAppConfig())
+
+	spec := yamlSpec{}
+	assert.Error(t, yaml.UnmarshalStrict([]byte("dbus: user"), &spec))
+}
+
+func TestDockerRunArgsForward(t *testing.T) {
+	filesystem.MkdirAll("/home/.config/git", 0755)
+	afero.WriteFile(filesystem, "/home/.gitconfig", []byte("[user]"), 0644)
+	afero.WriteFile(filesystem, "/home/.config/git/credentials", []byte("https://user:pw@host"), 0600)
+	afero.WriteFile(filesystem, "/tmp/ssh-agent.sock", []byte{}, 0600)
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "forward: [ssh-agent, gitconfig, git-credentials]")
+	defer mockEnvVars(map[string]string{"SSH_AUTH_SOCK": "/tmp/ssh-agent.sock"})()
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"-e", "SSH_AUTH_SOCK=/tmp/.containerflight.ssh-agent", "-v", "/tmp/ssh-agent.sock:/tmp/.containerflight.ssh-agent",
+		"-v", "/home/.gitconfig:/home/.gitconfig:ro",
+		"-v", "/home/.config/git/credentials:/home/.config/git/credentials:ro",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsForwardMissingSSHAgent(t *testing.T) {
+	testForLogFatal(t, func() {
+		appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "forward: [ssh-agent]")
+		defer mockEnvVars(map[string]string{})()
+		appInfo.GetDockerRunArgs()
+	})
+}
+
+func TestForwardUnknown(t *testing.T) {
+	testForLogFatal(t, func() {
+		NewFakeAppInfo(&filesystem, "/testAppFile", "forward: [gpg-agent]")
+	})
+}
+
+func TestDockerRunArgsSecurity(t *testing.T) {
+
+	appConfigStr :=
+		"security:\n" +
+			"    capabilities:\n" +
+			"        drop: [ALL]\n" +
+			"        add: [NET_BIND_SERVICE]\n" +
+			"    readOnlyRootfs: true\n" +
+			"    noNewPrivileges: true\n" +
+			"    seccomp: seccomp.json\n" +
+			"    noNetwork: true"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--cap-drop", "ALL", "--cap-add", "NET_BIND_SERVICE",
+		"--read-only", "--tmpfs", "/tmp",
+		"--security-opt", "no-new-privileges",
+		"--security-opt", "seccomp=/seccomp.json",
+		"--network", "none",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsStrictSecurity(t *testing.T) {
+
+	appConfigStr :=
+		"security:\n" +
+			"    capabilities:\n" +
+			"        add: [SYS_PTRACE]\n" +
+			"    readOnlyRootfs: false"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--cap-drop", "ALL", "--cap-add", "SYS_PTRACE",
+		"--security-opt", "no-new-privileges",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	appInfo.EnableStrictSecurity()
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestSecurityInvalidCapability(t *testing.T) {
+	testForLogFatal(t, func() {
+		NewFakeAppInfo(&filesystem, "/testAppFile", "security:\n    capabilities:\n        drop: [net-admin]")
+	})
+}
+
+func TestDockerRunArgsNetwork(t *testing.T) {
+
+	appConfigStr :=
+		"runtime:\n" +
+			"    network: mynet\n" +
+			"    ports: [\"127.0.0.1:8080:80\", \"53/udp\"]\n" +
+			"    extraHosts: [\"db:10.0.0.2\"]"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--network", "mynet", "-p", "127.0.0.1:8080:80", "-p", "53/udp", "--add-host", "db:10.0.0.2",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestNetworkConflicts(t *testing.T) {
+	appConfigStrs := []string{
+		"runtime:\n    network: none\n    ports: [\"8080:80\"]",
+		"runtime:\n    network: host\n    docker:\n        runargs: [\"--net=bridge\"]",
+		"security:\n    noNetwork: true\nruntime:\n    network: host",
+		"security:\n    noNetwork: true\nruntime:\n    docker:\n        runargs: [\"-p\", \"8080:80\"]",
+		"runtime:\n    ports: [\"http\"]",
+		"image:\n    network: \"no network\"",
+		"runtime:\n    network: mynet\n    docker:\n        runargs: [\"--network host\"]",
+		"runtime:\n    network: none\n    docker:\n        runargs: [\"-p8080:80\"]",
+		"runtime:\n    network: host\n    docker:\n        runargs: [\"-dP\"]",
+	}
+	for _, appConfigStr := range appConfigStrs {
+		testForLogFatal(t, func() {
+			NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+		})
+	}
+
+	// runargs of the command line
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "security:\n    noNetwork: true")
+	for _, runArgs := range [][]string{{"--network", "host"}, {"--net=bridge"}, {"--publish=8080:80"}, {"-itp", "8080:80"}} {
+		testForLogFatal(t, func() {
+			appInfo.AddDockerRunArgs(runArgs...)
+		})
+	}
+	appInfo.AddDockerRunArgs("-e", "PORT=8080", "-ti", "--publisher=me")
+}
+
+func TestIsDetached(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    docker:\n        runargs: [\"-dt\"]")
+	assert.True(t, appInfo.IsDetached())
+	appInfo.AddDockerRunArgs("--detach=false")
+	assert.False(t, appInfo.IsDetached())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	appInfo.AddDockerRunArgs("-e", "-hd", "--detach-keys", "ctrl-x")
+	assert.False(t, appInfo.IsDetached())
+	appInfo.AddDockerRunArgs("--detach")
+	assert.True(t, appInfo.IsDetached())
+}
+
+func TestGetNetworkRunArgOption(t *testing.T) {
+	assert.Equal(t, "--network", getNetworkRunArgOption(" --network=host"))
+	assert.Equal(t, "--network", getNetworkRunArgOption("--net host"))
+	assert.Equal(t, "--publish", getNetworkRunArgOption("-p8080:80"))
+	assert.Equal(t, "--publish", getNetworkRunArgOption("-tip"))
+	assert.Equal(t, "--publish-all", getNetworkRunArgOption("--publish-all"))
+	assert.Equal(t, "--publish-all", getNetworkRunArgOption("-dP"))
+	assert.Equal(t, "", getNetworkRunArgOption("-ep"))
+	assert.Equal(t, "", getNetworkRunArgOption("--networks"))
+	assert.Equal(t, "", getNetworkRunArgOption("host"))
+}
+
+func TestDockerRunArgsResources(t *testing.T) {
+
+	appConfigStr :=
+		"resources:\n" +
+			"    cpus: 1.5\n" +
+			"    memory: 2g\n" +
+			"    pidsLimit: 256\n" +
+			"    shmSize: 512m\n" +
+			"    ulimits:\n" +
+			"        nproc: 1024\n" +
+			"        nofile: 1024:2048"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--cpus", "1.5", "--memory", "2g", "--pids-limit", "256", "--shm-size", "512m",
+		"--ulimit", "nofile=1024:2048", "--ulimit", "nproc=1024",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsOverrideResources(t *testing.T) {
+
+	appConfigStr := "resources:\n    cpus: 1\n    memory: 2g"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--cpus", "1", "--memory", "4g",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	appInfo.OverrideResources("", "4g")
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestResourcesInvalid(t *testing.T) {
+	appConfigStrs := []string{
+		"resources:\n    cpus: 0",
+		"resources:\n    cpus: many",
+		"resources:\n    memory: 0",
+		"resources:\n    memory: 2 apples",
+		"resources:\n    pidsLimit: 0",
+		"resources:\n    shmSize: -1m",
+		"resources:\n    ulimits:\n        nofile: many",
+	}
+	for _, appConfigStr := range appConfigStrs {
+		testForLogFatal(t, func() {
+			NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+		})
+	}
+}
+
+func TestDockerRunArgsAdditional(t *testing.T) {
+
+	appConfigStr :=
+		"runtime:\n" +
+			"    docker:\n" +
+			"        runargs: [\"-e\", \"A=1\"]"
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	appInfo.AddDockerRunArgs("-v", "${HOME}/data:/data:ro", "-w", "/data")
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir",
+		"-e", "A=1", "-v", "/home/data:/data:ro", "-w", "/data", "-ti",
+		"-h", "flybydocker"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestDockerRunArgsNamedVolume(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	appInfo.AddDockerRunArgs("-v", "named:/named", "-v", "${HOME}/data:/data", "-v", "my.cache:/cache:ro")
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir",
+		"-v", "named:/named", "-v", "/home/data:/data", "-v", "my.cache:/cache:ro", "-ti",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+}
+
+func TestRuntimeModePersistent(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    mode: persistent\n    idleTimeout: 30s")
+	assert.True(t, appInfo.IsPersistent())
+	assert.Equal(t, 30*time.Second, appInfo.GetIdleTimeout())
+
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    mode: persistent")
+	assert.Equal(t, defaultIdleTimeout, appInfo.GetIdleTimeout())
+}
+
+func TestDockerRunArgsStopTimeout(t *testing.T) {
+
+	appConfigStr := "runtime:\n    init: false\n    stopTimeout: 1m"
+
+	expDockerRunArgs := []string{"-v", "/myworkingdir:/myworkingdir", "-ti",
+		"--stop-timeout", "60",
+		"-h", "flybydocker", "-w", "/myworkingdir"}
+
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	assert.Equal(t, expDockerRunArgs, appInfo.GetDockerRunArgs())
+	assert.False(t, appInfo.UseInit())
+
+	// rounded up to whole seconds
+	appInfo = NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    stopTimeout: 500ms")
+	assert.Contains(t, strings.Join(appInfo.GetDockerRunArgs(), " "), "--stop-timeout 1 ")
+}
+
+func TestRuntimeModeInvalid(t *testing.T) {
+	appConfigStrs := []string{
+		"runtime:\n    mode: forever",
+		"runtime:\n    idleTimeout: 10m",
+		"runtime:\n    mode: persistent\n    idleTimeout: 0s",
+		"runtime:\n    stopTimeout: soon",
+	}
+	for _, appConfigStr := range appConfigStrs {
+		testForLogFatal(t, func() {
+			NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+		})
+	}
+}
+
+func TestSecretsNotInResolvedAppConfig(t *testing.T) {
+	appConfigStr :=
+		"secrets:\n    - id: npmrc\n      file: ${HOME}/.npmrc\n" +
+			"image:\n    dockerfile: RUN --mount=type=secret,id=npmrc npm install"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	assert.NotRegexp(t, `\.npmrc`, appInfo.GetResolvedAppConfig())
+	assert.Equal(t, []BuildSecret{{ID: "npmrc", File: "/home/.npmrc"}}, appInfo.GetBuildSecrets())
+	assert.Regexp(t, "^# syntax = docker/dockerfile:1.2\n", appInfo.GetDockerfile())
+}
+
+func TestSecretsDigest(t *testing.T) {
+	afero.WriteFile(filesystem, "/apps/token", []byte("secret"), 0600)
+	defer filesystem.RemoveAll("/apps")
+
+	appConfigStr := "secrets:\n    - id: token\n      file: token"
+	appInfo := NewFakeAppInfo(&filesystem, "/apps/testAppFile", appConfigStr)
+
+	// relative to the app file
+	assert.Equal(t, []BuildSecret{{ID: "token", File: "/apps/token"}}, appInfo.GetBuildSecrets())
+	digest, ok := appInfo.GetBuildSecretsDigest([]byte("key"))
+	assert.True(t, ok)
+	assert.Len(t, digest, 64)
+
+	// the digest depends on the key
+	otherKeyDigest, _ := appInfo.GetBuildSecretsDigest([]byte("otherKey"))
+	assert.NotEqual(t, digest, otherKeyDigest)
+
+	afero.WriteFile(filesystem, "/apps/token", []byte("changed"), 0600)
+	changedDigest, _ := appInfo.GetBuildSecretsDigest([]byte("key"))
+	assert.NotEqual(t, digest, changedDigest)
+
+	// a missing secret is only an error when the image is built
+	filesystem.Remove("/apps/token")
+	_, ok = appInfo.GetBuildSecretsDigest([]byte("key"))
+	assert.False(t, ok)
+	testForLogFatal(t, func() {
+		appInfo.ReadBuildSecret(appInfo.GetBuildSecrets()[0])
+	})
+}
+
+func TestSecretsInvalid(t *testing.T) {
+	testForLogFatal(t, func() {
+		NewFakeAppInfo(&filesystem, "/testAppFile", "secrets:\n    - id: token\n      file: /token\n      env: TOKEN")
+	})
+}
+
+func TestBuildArgs(t *testing.T) {
+	appConfigStr := "image:\n    buildArgs:\n        VERSION: \"1.2\"\n        TOKEN: ${ENV(TOKEN)}\n        http_proxy: ${ENV(http_proxy)}"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	assert.NotRegexp(t, "VERSION", appInfo.GetResolvedAppConfig())
+	assert.Equal(t, map[string]string{"VERSION": "1.2", "TOKEN": "TOKEN", "http_proxy": "http_proxy"}, appInfo.GetBuildArgs())
+
+	// build args of the app file are hashed as written, proxy args are not hashed
+	assert.Equal(t, []string{"buildArg:TOKEN=${ENV(TOKEN)}", "buildArg:VERSION=1.2"}, appInfo.GetHashedBuildArgs())
+}
+
+func TestBuildArgsInDockerfile(t *testing.T) {
+	appConfigStr :=
+		"image:\n    buildArgs:\n        VERSION: \"1.2\"\n" +
+			"    dockerfile: |\n        ARG VERSION\n        RUN echo $VERSION ${VERSION}\n"
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	// ${...} is a parameter of the app file, Docker only gets $VERSION
+	assert.Regexp(t, `RUN echo \$VERSION <<ERROR!>>`, appInfo.GetDockerfile())
+}
+
+func TestOverrideBuildArgs(t *testing.T) {
+	appConfigStr := "image:\n    buildArgs:\n        VERSION: \"1.2\""
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	appInfo.OverrideBuildArgs([]string{"VERSION=1.3", "DEBUG", "HTTP_PROXY=http://proxy:3128"})
+
+	assert.Equal(t, map[string]string{"VERSION": "1.3", "DEBUG": "DEBUG", "HTTP_PROXY": "http://proxy:3128"}, appInfo.GetBuildArgs())
+	assert.Equal(t, []string{"buildArgOverride:DEBUG=DEBUG", "buildArgOverride:VERSION=1.3"}, appInfo.GetHashedBuildArgs())
+
+	testForLogFatal(t, func() { appInfo.OverrideBuildArgs([]string{"=1.3"}) })
+}
+
+func TestBuildArgsInvalid(t *testing.T) {
+	testForLogFatal(t, func() {
+		NewFakeAppInfo(&filesystem, "/testAppFile", "image:\n    buildArgs:\n        1VERSION: \"1.2\"")
+	})
+}
+
+func TestGuiModeInvalid(t *testing.T) {
+	spec := yamlSpec{}
+	err := yaml.UnmarshalStrict([]byte("gui: mir"), &spec)
+
+	assert.Error(t, err)
+}
+
+func TestGuiModeResolvedAppConfig(t *testing.T) {
+	appInfo := NewFakeAppInfo(&filesystem, "/testAppFile", "gui: true")
+
+	// keep boolean notation so that hash values do not change
+	assert.Regexp(t, "(?m)^gui: true$", appInfo.GetResolvedAppConfig())
+}
+
 // ---
 
 var dockerFileTmpl = `ENV http_proxy=http_proxy
@@ -365,6 +1086,19 @@ func testAppConfig(t *testing.T, expAppConfigStr string, appConfigStr string) (y
 	return expAppConfig, appInfo.appConfig
 }
 
+// replace the environment variables of the host for a test
+func mockEnvVars(envVars map[string]string) (restore func()) {
+	origGetEnvVar := getEnvVar
+	getEnvVar = fakeEnvVars(envVars)
+	return func() { getEnvVar = origGetEnvVar }
+}
+
+func fakeEnvVars(envVars map[string]string) func(string) string {
+	return func(name string) string {
+		return envVars[name]
+	}
+}
+
 func testForLogFatal(t *testing.T, testFunc func()) {
 
 	origLogFatalf := logFatalf
@@ -0,0 +1,82 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// location of the PulseAudio cookie in the container
+const containerPulseCookie = "/tmp/.containerflight.pulse-cookie"
+
+// get the docker run arguments to access the PulseAudio / PipeWire sound server of the host
+func (cfg *AppInfo) getAudioRunArgs() []string {
+	if !cfg.appConfig.Audio {
+		return []string{}
+	}
+
+	audioRunArgs := []string{}
+	runtimeDir := getEnvVar("XDG_RUNTIME_DIR")
+
+	// PulseAudio native socket (also provided by pipewire-pulse)
+	pulseSocket := ""
+	if pulseServer := getEnvVar("PULSE_SERVER"); strings.HasPrefix(pulseServer, "unix:") {
+		pulseSocket = strings.TrimPrefix(pulseServer, "unix:")
+	} else if runtimeDir != "" {
+		pulseSocket = filepath.Join(runtimeDir, "pulse", "native")
+	}
+	if _, err := filesystem.Stat(pulseSocket); pulseSocket != "" && err == nil {
+		audioRunArgs = append(audioRunArgs,
+			"-e", "PULSE_SERVER=unix:"+pulseSocket,
+			"-v", pulseSocket+":"+pulseSocket,
+		)
+
+		// the cookie is needed if the server does not trust the user id
+		cookieFiles := []string{
+			getEnvVar("PULSE_COOKIE"),
+			filepath.Join(cfg.env.homeDir, ".config", "pulse", "cookie"),
+			filepath.Join(cfg.env.homeDir, ".pulse-cookie"),
+		}
+		for _, cookieFile := range cookieFiles {
+			if _, err := filesystem.Stat(cookieFile); cookieFile != "" && err == nil {
+				audioRunArgs = append(audioRunArgs,
+					"-e", "PULSE_COOKIE="+containerPulseCookie,
+					"-v", cookieFile+":"+containerPulseCookie+":ro",
+				)
+				break
+			}
+		}
+	}
+
+	// PipeWire native socket
+	if runtimeDir != "" {
+		pipewireSocket := filepath.Join(runtimeDir, "pipewire-0")
+		if _, err := filesystem.Stat(pipewireSocket); err == nil {
+			audioRunArgs = append(audioRunArgs,
+				"-e", "PIPEWIRE_RUNTIME_DIR="+runtimeDir,
+				"-v", pipewireSocket+":"+pipewireSocket,
+			)
+		}
+	}
+
+	if len(audioRunArgs) == 0 {
+		log.Warn("no PulseAudio or PipeWire socket found, audio is not available")
+	}
+
+	return audioRunArgs
+}
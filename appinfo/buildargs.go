@@ -0,0 +1,100 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var buildArgNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// build args which are predefined by Docker, they do not invalidate the build cache and thus are
+// never hashed
+var predefinedBuildArgs = map[string]bool{
+	"HTTP_PROXY": true, "http_proxy": true,
+	"HTTPS_PROXY": true, "https_proxy": true,
+	"FTP_PROXY": true, "ftp_proxy": true,
+	"NO_PROXY": true, "no_proxy": true,
+	"ALL_PROXY": true, "all_proxy": true,
+}
+
+// check the build args of an app file
+func validateBuildArgs(buildArgs map[string]string) {
+	for name := range buildArgs {
+		if !buildArgNameRegex.MatchString(name) {
+			logFatalf("Invalid build arg name \"%s\"!", name)
+		}
+	}
+}
+
+// OverrideBuildArgs sets build args from the command line ("NAME=VALUE" or "NAME" to take the value
+// from the environment), they replace the build args of the app file
+func (cfg *AppInfo) OverrideBuildArgs(buildArgs []string) {
+	for _, buildArg := range buildArgs {
+		nameValue := strings.SplitN(buildArg, "=", 2)
+		if !buildArgNameRegex.MatchString(nameValue[0]) {
+			logFatalf("Invalid build arg \"%s\" (NAME=VALUE or NAME)!", buildArg)
+		}
+		if len(nameValue) == 1 {
+			nameValue = append(nameValue, getEnvVar(nameValue[0]))
+		}
+		if cfg.buildArgOverrides == nil {
+			cfg.buildArgOverrides = map[string]string{}
+		}
+		cfg.buildArgOverrides[nameValue[0]] = nameValue[1]
+	}
+}
+
+// GetBuildArgs returns the resolved build args of the app file and the command line
+func (cfg *AppInfo) GetBuildArgs() map[string]string {
+	buildArgs := map[string]string{}
+	for name, value := range cfg.appConfig.Image.BuildArgs {
+		cfg.replaceParameters(&value)
+		buildArgs[name] = value
+	}
+	for name, value := range cfg.buildArgOverrides {
+		buildArgs[name] = value
+	}
+	return buildArgs
+}
+
+// GetHashedBuildArgs returns the build args which distinguish app images: build args of the app file
+// are hashed as written (a changed environment variable does not trigger a rebuild), build args
+// of the command line are hashed with their value and Docker's predefined proxy args are not hashed
+func (cfg *AppInfo) GetHashedBuildArgs() []string {
+	hashedBuildArgs := map[string]string{}
+	for name, value := range cfg.appConfig.Image.BuildArgs {
+		hashedBuildArgs[name] = "buildArg:" + name + "=" + value
+	}
+	for name, value := range cfg.buildArgOverrides {
+		hashedBuildArgs[name] = "buildArgOverride:" + name + "=" + value
+	}
+
+	names := []string{}
+	for name := range hashedBuildArgs {
+		if !predefinedBuildArgs[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := []string{}
+	for _, name := range names {
+		result = append(result, hashedBuildArgs[name])
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Dockerfile frontends of BuildKit
+const (
+	// external frontend which supports "RUN --mount" (secrets and caches) with Docker 18.09 to 19.03
+	buildKitDockerfileSyntax = "docker/dockerfile:1.2"
+	// frontend which is built into the Docker daemon
+	builtinDockerfileSyntax = "builtin"
+)
+
+var dockerfileSyntaxRegex = regexp.MustCompile(`^[^\s]+$`)
+var runMountRegex = regexp.MustCompile(`(?m)^\s*RUN\s+--mount=`)
+
+// cache mounts of the package managers which are used by the install macros, the caches are
+// shared by all images which are built by the same Docker daemon
+var buildCacheMounts = map[string][]string{
+	"APT_INSTALL": {
+		"type=cache,target=/var/cache/apt,sharing=locked",
+		"type=cache,target=/var/lib/apt/lists,sharing=locked",
+	},
+}
+
+// check the Dockerfile frontend ("builtin" or an image reference)
+func validateDockerfileSyntax(syntax string) {
+	if syntax != "" && !dockerfileSyntaxRegex.MatchString(syntax) {
+		logFatalf("Invalid Dockerfile syntax \"%s\" (builtin or an image like %s)!", syntax, buildKitDockerfileSyntax)
+	}
+}
+
+// EnableBuildKit generates a Dockerfile for BuildKit, install macros use cache mounts. The built-in
+// Dockerfile frontend of Docker 20.10 supports "RUN --mount", older daemons need an external frontend.
+func (cfg *AppInfo) EnableBuildKit(builtinMounts bool) {
+	cfg.buildKit = true
+	cfg.builtinMounts = builtinMounts
+}
+
+// get the "# syntax" line of a resolved Dockerfile, an external frontend is pulled by BuildKit and
+// therefore only selected if "RUN --mount" is used and not supported by the built-in frontend
+func (cfg *AppInfo) getDockerfileSyntax(dockerfile string) string {
+	syntax := cfg.appConfig.Image.Syntax
+	if syntax == "" {
+		if cfg.builtinMounts || !runMountRegex.MatchString(dockerfile) {
+			return ""
+		}
+		syntax = buildKitDockerfileSyntax
+	}
+	if syntax == builtinDockerfileSyntax {
+		return ""
+	}
+	return "# syntax = " + syntax + "\n"
+}
+
+// get the "RUN" instruction of ${APT_INSTALL(...)}
+func (cfg *AppInfo) getAptInstall(packages []string) string {
+	if !cfg.buildKit {
+		return "RUN apt-get update && \\\n" +
+			"    export DEBIAN_FRONTEND=noninteractive && \\\n" +
+			"    apt-get install -y " + strings.Join(packages, " ") + " && \\\n" +
+			"    rm -rf /var/lib/apt/lists/*"
+	}
+
+	// the package lists are not part of the image since they are stored in a cache mount, downloaded
+	// packages must not be removed by the docker-clean hook of the Debian images
+	return "RUN " + getCacheMountArgs("APT_INSTALL") +
+		"rm -f /etc/apt/apt.conf.d/docker-clean && \\\n" +
+		"    apt-get update && \\\n" +
+		"    export DEBIAN_FRONTEND=noninteractive && \\\n" +
+		"    apt-get install -y " + strings.Join(packages, " ")
+}
+
+// get the "--mount" arguments of a "RUN" instruction for the caches of a macro
+func getCacheMountArgs(macro string) string {
+	mountArgs := ""
+	for _, cacheMount := range buildCacheMounts[macro] {
+		mountArgs += "--mount=" + cacheMount + " \\\n    "
+	}
+	return mountArgs
+}
@@ -0,0 +1,150 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"os"
+)
+
+// "mock connectors" for unit-tesing
+var isTerminal = func(file *os.File) bool {
+	fi, err := file.Stat()
+	return err == nil && (fi.Mode()&os.ModeCharDevice) != 0
+}
+
+// console settings of an app, either a boolean or a mapping with "tty" and "interactive"
+type consoleSpec struct {
+	Enabled     *bool `yaml:"-"`
+	TTY         *bool `yaml:"tty,omitempty"`
+	Interactive *bool `yaml:",omitempty"`
+}
+
+// UnmarshalYAML accepts a boolean or a mapping
+func (console *consoleSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*console = consoleSpec{Enabled: &enabled}
+		return nil
+	}
+
+	type plainConsoleSpec consoleSpec
+	var plainConsole plainConsoleSpec
+	if err := unmarshal(&plainConsole); err != nil {
+		return err
+	}
+	*console = consoleSpec(plainConsole)
+	return nil
+}
+
+// MarshalYAML keeps the boolean notation so that the hash of existing app files does not change
+func (console consoleSpec) MarshalYAML() (interface{}, error) {
+	if console.Enabled != nil {
+		return *console.Enabled, nil
+	}
+	type plainConsoleSpec consoleSpec
+	return plainConsoleSpec(console), nil
+}
+
+// console settings which override the app file
+type consoleOverrides struct {
+	console     *bool
+	tty         *bool
+	interactive *bool
+}
+
+// IsConsoleApp returns true if stdin is opened or a TTY is allocated for the app
+func (cfg *AppInfo) IsConsoleApp() bool {
+	tty, interactive := cfg.getConsoleMode()
+	return tty || interactive
+}
+
+// HasTTY returns true if a TTY is allocated for the app
+func (cfg *AppInfo) HasTTY() bool {
+	tty, _ := cfg.getConsoleMode()
+	return tty
+}
+
+// IsInteractive returns true if stdin is opened for the app
+func (cfg *AppInfo) IsInteractive() bool {
+	_, interactive := cfg.getConsoleMode()
+	return interactive
+}
+
+// EnableConsole forces an app to run with stdin and a TTY (if possible) regardless of the app file
+func (cfg *AppInfo) EnableConsole() {
+	cfg.console.console = boolPtr(true)
+}
+
+// DisableConsole forces an app to run without TTY and stdin regardless of the app file
+func (cfg *AppInfo) DisableConsole() {
+	cfg.console.console = boolPtr(false)
+}
+
+// SetTTY forces an app to run with or without TTY
+func (cfg *AppInfo) SetTTY(tty bool) {
+	cfg.console.tty = &tty
+}
+
+// SetInteractive forces an app to run with or without stdin
+func (cfg *AppInfo) SetInteractive(interactive bool) {
+	cfg.console.interactive = &interactive
+}
+
+// GetConsoleArgs returns the docker arguments which open stdin and allocate a TTY
+func (cfg *AppInfo) GetConsoleArgs() []string {
+	tty, interactive := cfg.getConsoleMode()
+	switch {
+	case tty && interactive:
+		return []string{"-ti"}
+	case interactive:
+		return []string{"-i"}
+	case tty:
+		return []string{"-t"}
+	}
+	return []string{}
+}
+
+// determine if a TTY is allocated and if stdin is opened: command line options take precedence over
+// the app file, without both a TTY is only allocated if stdin, stdout and stderr are terminals
+func (cfg *AppInfo) getConsoleMode() (tty bool, interactive bool) {
+	appConsole := consoleSpec{}
+	if cfg.appConfig.Console != nil {
+		appConsole = *cfg.appConfig.Console
+	}
+	if cfg.console.console != nil {
+		// overrides the complete console settings of the app file
+		appConsole = consoleSpec{Enabled: cfg.console.console}
+	}
+
+	enabled := appConsole.Enabled == nil || *appConsole.Enabled
+
+	interactive = enabled
+	if appConsole.Interactive != nil {
+		interactive = *appConsole.Interactive
+	}
+	if cfg.console.interactive != nil {
+		interactive = *cfg.console.interactive
+	}
+
+	tty = enabled && isTerminal(os.Stdin) && isTerminal(os.Stdout) && isTerminal(os.Stderr)
+	if appConsole.TTY != nil {
+		tty = *appConsole.TTY
+	}
+	if cfg.console.tty != nil {
+		tty = *cfg.console.tty
+	}
+
+	return tty, interactive
+}
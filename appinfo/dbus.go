@@ -0,0 +1,161 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// location of the filtered D-Bus socket in the container
+const containerDbusProxySocket = "/tmp/.containerflight.dbus"
+
+// D-Bus access of an app, either "dbus: <bus>" or a mapping with a whitelist of names
+type dbusSpec struct {
+	Bus  string
+	Talk []string `yaml:",omitempty"`
+}
+
+// UnmarshalYAML accepts a bus name ("session", "system" or "none") or a mapping
+func (spec *dbusSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&spec.Bus); err != nil {
+		type dbusSpecMapping dbusSpec
+		if err := unmarshal((*dbusSpecMapping)(spec)); err != nil {
+			return err
+		}
+	}
+	switch spec.Bus {
+	case "", "none", "session", "system":
+	default:
+		return fmt.Errorf("invalid D-Bus \"%s\" (session, system or none)", spec.Bus)
+	}
+	return nil
+}
+
+// MarshalYAML uses the short notation if no names are whitelisted
+func (spec dbusSpec) MarshalYAML() (interface{}, error) {
+	if len(spec.Talk) == 0 {
+		return spec.Bus, nil
+	}
+	type dbusSpecMapping dbusSpec
+	return dbusSpecMapping(spec), nil
+}
+
+// start a filtering D-Bus proxy, can be replaced for unit-testing
+var startDbusProxy = func(busAddress string, socket string, talk []string) (stop func(), err error) {
+	args := []string{busAddress, socket, "--filter"}
+	for _, name := range talk {
+		args = append(args, "--talk="+name)
+	}
+	cmd := exec.Command("xdg-dbus-proxy", args...)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	// wait until the proxy listens
+	for i := 0; i < 100; i++ {
+		if _, err := filesystem.Stat(socket); err == nil {
+			return stop, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	stop()
+	return nil, fmt.Errorf("xdg-dbus-proxy did not create %s", socket)
+}
+
+// return the socket path of a D-Bus address ("unix:path=/run/user/1000/bus,guid=...")
+func getDbusSocket(busAddress string) string {
+	for _, address := range strings.Split(busAddress, ";") {
+		if !strings.HasPrefix(address, "unix:") {
+			continue
+		}
+		for _, keyValue := range strings.Split(strings.TrimPrefix(address, "unix:"), ",") {
+			if strings.HasPrefix(keyValue, "path=") {
+				return strings.TrimPrefix(keyValue, "path=")
+			}
+		}
+	}
+	return ""
+}
+
+// return true if the access to the bus is restricted by a D-Bus proxy
+func (cfg *AppInfo) usesDbusProxy() bool {
+	bus := cfg.appConfig.Dbus.Bus
+	return bus != "" && bus != "none" && len(cfg.appConfig.Dbus.Talk) > 0
+}
+
+// get the docker run arguments to access the D-Bus session or system bus of the host
+func (cfg *AppInfo) getDbusRunArgs() []string {
+	bus := cfg.appConfig.Dbus.Bus
+	if bus == "" || bus == "none" {
+		return []string{}
+	}
+
+	busAddressVar := "DBUS_SESSION_BUS_ADDRESS"
+	busAddress := getEnvVar(busAddressVar)
+	if bus == "system" {
+		busAddressVar = "DBUS_SYSTEM_BUS_ADDRESS"
+		busAddress = getEnvVar(busAddressVar)
+		if busAddress == "" {
+			busAddress = "unix:path=/run/dbus/system_bus_socket"
+		}
+	} else if busAddress == "" && getEnvVar("XDG_RUNTIME_DIR") != "" {
+		busAddress = "unix:path=" + filepath.Join(getEnvVar("XDG_RUNTIME_DIR"), "bus")
+	}
+
+	socket := getDbusSocket(busAddress)
+	if socket == "" {
+		log.Warnf("D-Bus address \"%s\" cannot be shared with a container (only \"unix:path=...\" is supported)", busAddress)
+		return []string{}
+	}
+
+	if len(cfg.appConfig.Dbus.Talk) == 0 {
+		return []string{
+			"-e", busAddressVar + "=unix:path=" + socket,
+			"-v", socket + ":" + socket,
+		}
+	}
+
+	// restrict access to whitelisted names
+	proxyDir, err := afero.TempDir(filesystem, "", "containerflight-dbus")
+	if err != nil {
+		logFatalf("Cannot create D-Bus proxy directory: %v", err)
+		return []string{}
+	}
+	cfg.addCleanup(func() { filesystem.RemoveAll(proxyDir) })
+
+	proxySocket := filepath.Join(proxyDir, "bus")
+	stopProxy, err := startDbusProxy(busAddress, proxySocket, cfg.appConfig.Dbus.Talk)
+	if err != nil {
+		logFatalf("Cannot start D-Bus proxy (is xdg-dbus-proxy installed?): %v", err)
+		return []string{}
+	}
+	cfg.addCleanup(stopProxy)
+
+	return []string{
+		"-e", busAddressVar + "=unix:path=" + containerDbusProxySocket,
+		"-v", proxySocket + ":" + containerDbusProxySocket,
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"path/filepath"
+)
+
+// location of the forwarded ssh-agent socket in the container
+const containerSSHAuthSock = "/tmp/.containerflight.ssh-agent"
+
+// host resources which can be forwarded into an app container at run time
+var forwards = map[string]func(cfg *AppInfo) []string{
+	"ssh-agent":       (*AppInfo).getSSHAgentRunArgs,
+	"gitconfig":       (*AppInfo).getGitconfigRunArgs,
+	"git-credentials": (*AppInfo).getGitCredentialsRunArgs,
+}
+
+// check that all forwards are known
+func validateForwards(forwardNames []string) {
+	for _, forwardName := range forwardNames {
+		if _, ok := forwards[forwardName]; !ok {
+			logFatalf("Unknown forward \"%s\" (ssh-agent, gitconfig or git-credentials)!", forwardName)
+		}
+	}
+}
+
+// get the docker run arguments for all forwards, the forwarded files are never part of the image
+func (cfg *AppInfo) getForwardRunArgs() []string {
+	forwardRunArgs := []string{}
+	for _, forwardName := range cfg.appConfig.Forward {
+		if getRunArgs, ok := forwards[forwardName]; ok {
+			forwardRunArgs = append(forwardRunArgs, getRunArgs(cfg)...)
+		}
+	}
+	return forwardRunArgs
+}
+
+// forward the ssh-agent socket
+func (cfg *AppInfo) getSSHAgentRunArgs() []string {
+	sshAuthSock := getEnvVar("SSH_AUTH_SOCK")
+	if sshAuthSock == "" {
+		logFatalf("Cannot forward ssh-agent: SSH_AUTH_SOCK is not set!")
+		return []string{}
+	}
+	if _, err := filesystem.Stat(sshAuthSock); err != nil {
+		logFatalf("Cannot forward ssh-agent: %v", err)
+		return []string{}
+	}
+	return []string{
+		"-e", "SSH_AUTH_SOCK=" + containerSSHAuthSock,
+		"-v", sshAuthSock + ":" + containerSSHAuthSock,
+	}
+}
+
+// forward the git configuration of the user read-only
+func (cfg *AppInfo) getGitconfigRunArgs() []string {
+	return cfg.getHomeFilesRunArgs("gitconfig", []string{
+		".gitconfig",
+		filepath.Join(".config", "git", "config"),
+	})
+}
+
+// forward the git credential store of the user read-only
+func (cfg *AppInfo) getGitCredentialsRunArgs() []string {
+	runArgs := cfg.getHomeFilesRunArgs("git-credentials", []string{
+		".git-credentials",
+		filepath.Join(".config", "git", "credentials"),
+	})
+
+	// socket of the "cache" credential helper
+	cacheSocket := filepath.Join(cfg.env.homeDir, ".cache", "git", "credential", "socket")
+	if _, err := filesystem.Stat(cacheSocket); err == nil {
+		runArgs = append(runArgs, "-v", cacheSocket+":"+cacheSocket)
+	}
+
+	return runArgs
+}
+
+// mount existing files of the home directory read-only to the same location, at least one file must exist
+func (cfg *AppInfo) getHomeFilesRunArgs(forwardName string, relFileNames []string) []string {
+	runArgs := []string{}
+	for _, relFileName := range relFileNames {
+		fileName := filepath.Join(cfg.env.homeDir, relFileName)
+		if _, err := filesystem.Stat(fileName); err == nil {
+			runArgs = append(runArgs, "-v", fileName+":"+fileName+":ro")
+		}
+	}
+	if len(runArgs) == 0 {
+		logFatalf("Cannot forward %s: ~/%s does not exist!", forwardName, relFileNames[0])
+	}
+	return runArgs
+}
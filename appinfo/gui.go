@@ -0,0 +1,220 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+)
+
+// display server access of an app
+type guiMode string
+
+const (
+	guiNone    guiMode = ""
+	guiAuto    guiMode = "auto"
+	guiX11     guiMode = "x11"
+	guiWayland guiMode = "wayland"
+)
+
+// UnmarshalYAML accepts a boolean ("true" means "auto") or a gui mode
+func (mode *guiMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*mode = guiNone
+		if enabled {
+			*mode = guiAuto
+		}
+		return nil
+	}
+
+	var modeStr string
+	if err := unmarshal(&modeStr); err != nil {
+		return err
+	}
+	switch guiMode(modeStr) {
+	case guiAuto, guiX11, guiWayland:
+		*mode = guiMode(modeStr)
+	default:
+		return fmt.Errorf("invalid gui mode \"%s\" (true, false, auto, x11 or wayland)", modeStr)
+	}
+	return nil
+}
+
+// MarshalYAML keeps the boolean notation so that the hash of existing app files does not change
+func (mode guiMode) MarshalYAML() (interface{}, error) {
+	switch mode {
+	case guiNone:
+		return false, nil
+	case guiAuto:
+		return true, nil
+	}
+	return string(mode), nil
+}
+
+// location of the generated Xauthority file in the container
+const containerXauthority = "/tmp/.containerflight.xauth"
+
+// default hostname of an app container
+const defaultHostname = "flybydocker"
+
+var xauthCookieRegex = regexp.MustCompile(`^\S+\s+MIT-MAGIC-COOKIE-1\s+([[:xdigit:]]+)\s*$`)
+
+// run xauth on the host, can be replaced for unit-testing
+var runXauth = func(args ...string) (string, error) {
+	output, err := exec.Command("xauth", args...).Output()
+	return string(output), err
+}
+
+// return the Wayland socket of the host or an empty string if there is none
+func getWaylandSocket() string {
+	waylandDisplay := getEnvVar("WAYLAND_DISPLAY")
+	if waylandDisplay == "" {
+		return ""
+	}
+
+	socket := waylandDisplay
+	if !filepath.IsAbs(socket) {
+		runtimeDir := getEnvVar("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			return ""
+		}
+		socket = filepath.Join(runtimeDir, waylandDisplay)
+	}
+
+	if _, err := filesystem.Stat(socket); err != nil {
+		return ""
+	}
+	return socket
+}
+
+// return true if the app uses the X server of the host, as fallback or for XWayland if a Wayland
+// session provides it
+func (cfg *AppInfo) usesX11() bool {
+	mode := cfg.appConfig.Gui
+	useWayland := mode == guiWayland || (mode == guiAuto && getWaylandSocket() != "")
+	return mode == guiX11 || (mode == guiAuto && (!useWayland || getEnvVar("DISPLAY") != ""))
+}
+
+// get the docker run arguments to access the display server of the host
+func (cfg *AppInfo) getGuiRunArgs() []string {
+	mode := cfg.appConfig.Gui
+	if mode == guiNone {
+		return []string{}
+	}
+
+	guiRunArgs := []string{}
+
+	waylandSocket := getWaylandSocket()
+	useWayland := mode == guiWayland || (mode == guiAuto && waylandSocket != "")
+	if useWayland {
+		if waylandSocket == "" {
+			logFatalf("No Wayland socket found (check WAYLAND_DISPLAY and XDG_RUNTIME_DIR)!")
+			return []string{}
+		}
+		// the socket is mounted to the same location as on the host, an absolute WAYLAND_DISPLAY
+		// does not need XDG_RUNTIME_DIR
+		if runtimeDir := getEnvVar("XDG_RUNTIME_DIR"); runtimeDir != "" {
+			guiRunArgs = append(guiRunArgs, "-e", "XDG_RUNTIME_DIR="+runtimeDir)
+		}
+		guiRunArgs = append(guiRunArgs,
+			"-e", "WAYLAND_DISPLAY="+getEnvVar("WAYLAND_DISPLAY"),
+			"-v", waylandSocket+":"+waylandSocket,
+		)
+	}
+
+	if cfg.usesX11() {
+		guiRunArgs = append(guiRunArgs,
+			"-e", "DISPLAY="+getEnvVar("DISPLAY"),
+			"-v", "/tmp/.X11-unix:/tmp/.X11-unix",
+		)
+		if xauthority := cfg.createXauthority(); xauthority != "" {
+			guiRunArgs = append(guiRunArgs,
+				"-e", "XAUTHORITY="+containerXauthority,
+				"-v", xauthority+":"+containerXauthority+":ro",
+			)
+		}
+	}
+
+	return guiRunArgs
+}
+
+// return the hostname of the app container
+func (cfg *AppInfo) getHostname() string {
+	runArgs := cfg.appConfig.Runtime.Docker.RunArgs
+	for i, arg := range runArgs {
+		if (arg == "-h" || arg == "--hostname") && i+1 < len(runArgs) {
+			hostname := runArgs[i+1]
+			cfg.replaceParameters(&hostname)
+			return hostname
+		}
+		if strings.HasPrefix(arg, "--hostname=") {
+			hostname := strings.TrimPrefix(arg, "--hostname=")
+			cfg.replaceParameters(&hostname)
+			return hostname
+		}
+	}
+	return defaultHostname
+}
+
+// create a temporary Xauthority file with the X11 cookie of the host for the hostname of the app container
+// so that "xhost +local:" is not required, the file is removed by Cleanup()
+func (cfg *AppInfo) createXauthority() string {
+	display := getEnvVar("DISPLAY")
+	if display == "" {
+		return ""
+	}
+
+	// look up cookie of the current display
+	output, err := runXauth("list", display)
+	cookie := ""
+	for _, line := range strings.Split(output, "\n") {
+		if match := xauthCookieRegex.FindStringSubmatch(line); match != nil {
+			cookie = match[1]
+			break
+		}
+	}
+	if err != nil || cookie == "" {
+		log.Warn("cannot read X11 cookie via xauth, access to the X server must be granted by xhost")
+		return ""
+	}
+
+	// display number without screen ("host:0.0" -> "0")
+	displayNumber := display[strings.LastIndex(display, ":")+1:]
+	displayNumber = strings.SplitN(displayNumber, ".", 2)[0]
+
+	xauthorityFile, err := afero.TempFile(filesystem, "", "containerflight.xauth")
+	if err != nil {
+		log.Warn("cannot create Xauthority file: ", err)
+		return ""
+	}
+	xauthorityFile.Close()
+	xauthority := xauthorityFile.Name()
+	cfg.addCleanup(func() { filesystem.Remove(xauthority) })
+
+	_, err = runXauth("-f", xauthority, "add", cfg.getHostname()+"/unix:"+displayNumber, "MIT-MAGIC-COOKIE-1", cookie)
+	if err != nil {
+		log.Warn("cannot write Xauthority file: ", err)
+		return ""
+	}
+
+	return xauthority
+}
@@ -0,0 +1,87 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// runtime modes of an app
+const (
+	// a new container for each invocation (default)
+	modeEphemeral = "ephemeral"
+	// one long-lived container per app file and working directory, invocations are executed in it
+	modePersistent = "persistent"
+)
+
+// a persistent container is stopped if it has not been used for this time
+const defaultIdleTimeout = 10 * time.Minute
+
+// check the runtime mode
+func validateRuntimeMode(appInfoConfig yamlSpec) {
+	switch appInfoConfig.Runtime.Mode {
+	case "", modeEphemeral, modePersistent:
+	default:
+		logFatalf("Invalid runtime mode \"%s\" (ephemeral or persistent)!", appInfoConfig.Runtime.Mode)
+	}
+
+	if appInfoConfig.Runtime.StopTimeout != "" {
+		stopTimeout, err := time.ParseDuration(appInfoConfig.Runtime.StopTimeout)
+		if err != nil || stopTimeout < 0 {
+			logFatalf("Invalid stopTimeout \"%s\" (e.g. 10s)!", appInfoConfig.Runtime.StopTimeout)
+		}
+	}
+
+	if appInfoConfig.Runtime.IdleTimeout != "" {
+		if appInfoConfig.Runtime.Mode != modePersistent {
+			logFatalf("An idleTimeout requires the runtime mode \"persistent\"!")
+		}
+		idleTimeout, err := time.ParseDuration(appInfoConfig.Runtime.IdleTimeout)
+		if err != nil || idleTimeout < time.Second {
+			logFatalf("Invalid idleTimeout \"%s\" (at least one second, e.g. 30s or 10m)!", appInfoConfig.Runtime.IdleTimeout)
+		}
+	}
+}
+
+// UseInit returns true if an init process should reap zombies and forward signals in the container (default)
+func (cfg *AppInfo) UseInit() bool {
+	return cfg.appConfig.Runtime.Init == nil || *cfg.appConfig.Runtime.Init
+}
+
+// get the docker run arguments of the time the app gets to exit after SIGTERM before it is killed
+func (cfg *AppInfo) getStopTimeoutRunArgs() []string {
+	stopTimeout, err := time.ParseDuration(cfg.appConfig.Runtime.StopTimeout)
+	if err != nil {
+		return []string{}
+	}
+	// Docker only knows whole seconds, a shorter timeout must not kill the app immediately
+	return []string{"--stop-timeout", strconv.Itoa(int(math.Ceil(stopTimeout.Seconds())))}
+}
+
+// IsPersistent returns true if invocations of the app are executed in a long-lived container
+func (cfg *AppInfo) IsPersistent() bool {
+	return cfg.appConfig.Runtime.Mode == modePersistent
+}
+
+// GetIdleTimeout returns the time after which an unused persistent container is stopped
+func (cfg *AppInfo) GetIdleTimeout() time.Duration {
+	idleTimeout, err := time.ParseDuration(cfg.appConfig.Runtime.IdleTimeout)
+	if err != nil {
+		return defaultIdleTimeout
+	}
+	return idleTimeout
+}
@@ -0,0 +1,135 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// networks of Docker which need special treatment
+const (
+	networkNone = "none"
+	networkHost = "host"
+)
+
+var networkNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+var portRegex = regexp.MustCompile(`^((\[[0-9a-fA-F:.]+\]|[0-9.]+):)?(([0-9]+(-[0-9]+)?):)?[0-9]+(-[0-9]+)?(/(tcp|udp|sctp))?$`)
+var extraHostRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*:.+$`)
+
+// check the network settings of the image build and the runtime
+func validateNetwork(appInfoConfig yamlSpec) {
+	buildNetwork := appInfoConfig.Image.Network
+	if buildNetwork != "" && !networkNameRegex.MatchString(buildNetwork) {
+		logFatalf("Invalid build network \"%s\" (none, bridge, host or the name of a network)!", buildNetwork)
+	}
+
+	network := getRuntimeNetwork(appInfoConfig)
+	if appInfoConfig.Runtime.Network != "" && !networkNameRegex.MatchString(appInfoConfig.Runtime.Network) {
+		logFatalf("Invalid network \"%s\" (none, bridge, host or the name of a network)!", appInfoConfig.Runtime.Network)
+	}
+	if appInfoConfig.Security != nil && appInfoConfig.Security.NoNetwork && network != networkNone {
+		logFatalf("Network \"%s\" conflicts with \"noNetwork\" of the security section!", network)
+	}
+
+	for _, port := range appInfoConfig.Runtime.Ports {
+		if !portRegex.MatchString(port) {
+			logFatalf("Invalid port \"%s\" (e.g. 8080:80 or 127.0.0.1:8080:80/tcp)!", port)
+		}
+	}
+	if len(appInfoConfig.Runtime.Ports) > 0 && (network == networkNone || network == networkHost) {
+		logFatalf("Ports cannot be published with network \"%s\"!", network)
+	}
+
+	for _, extraHost := range appInfoConfig.Runtime.ExtraHosts {
+		if !extraHostRegex.MatchString(extraHost) {
+			logFatalf("Invalid extra host \"%s\" (e.g. myhost:192.168.0.1)!", extraHost)
+		}
+	}
+
+	checkNetworkRunArgs(network, appInfoConfig.Runtime.Docker.RunArgs)
+}
+
+// get the network of the app container (empty for the Docker default)
+func getRuntimeNetwork(appInfoConfig yamlSpec) string {
+	if appInfoConfig.Runtime.Network == "" && appInfoConfig.Security != nil && appInfoConfig.Security.NoNetwork {
+		return networkNone
+	}
+	return appInfoConfig.Runtime.Network
+}
+
+// raw runargs must not change the network behind the back of the network settings
+func checkNetworkRunArgs(network string, runArgs []string) {
+	for _, runArg := range runArgs {
+		switch getNetworkRunArgOption(runArg) {
+		case "--network":
+			if network != "" {
+				logFatalf("Runarg \"%s\" conflicts with network \"%s\"!", strings.TrimSpace(runArg), network)
+			}
+		case "--publish", "--publish-all":
+			if network == networkNone || network == networkHost {
+				logFatalf("Runarg \"%s\" conflicts with network \"%s\"!", strings.TrimSpace(runArg), network)
+			}
+		}
+	}
+}
+
+// return the network option ("--network", "--publish" or "--publish-all") of a docker run argument,
+// the value can be attached ("--network=host", "--network host", "-p8080:80") and short options can
+// be combined ("-dP")
+func getNetworkRunArgOption(runArg string) string {
+	runArg = strings.TrimSpace(runArg)
+	if strings.HasPrefix(runArg, "--") {
+		switch strings.FieldsFunc(runArg, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })[0] {
+		case "--network", "--net":
+			return "--network"
+		case "--publish":
+			return "--publish"
+		case "--publish-all":
+			return "--publish-all"
+		}
+		return ""
+	}
+	switch {
+	case hasShortRunOption(runArg, 'p'):
+		return "--publish"
+	case hasShortRunOption(runArg, 'P'):
+		return "--publish-all"
+	}
+	return ""
+}
+
+// GetBuildNetwork returns the network which is used by "RUN" instructions during the image build
+// (empty for the Docker default)
+func (cfg *AppInfo) GetBuildNetwork() string {
+	return cfg.appConfig.Image.Network
+}
+
+// get the docker run arguments of the network settings
+func (cfg *AppInfo) getNetworkRunArgs() []string {
+	runArgs := []string{}
+	if cfg.appConfig.Runtime.Network != "" {
+		runArgs = append(runArgs, "--network", cfg.appConfig.Runtime.Network)
+	}
+	for _, port := range cfg.appConfig.Runtime.Ports {
+		runArgs = append(runArgs, "-p", port)
+	}
+	for _, extraHost := range cfg.appConfig.Runtime.ExtraHosts {
+		cfg.replaceParameters(&extraHost)
+		runArgs = append(runArgs, "--add-host", extraHost)
+	}
+	return runArgs
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"sort"
+	"strconv"
+
+	units "github.com/docker/go-units"
+)
+
+// resource limits of an app container
+type resourcesSpec struct {
+	Cpus      string            `yaml:",omitempty"`
+	Memory    string            `yaml:",omitempty"`
+	PidsLimit *int              `yaml:"pidsLimit,omitempty"`
+	ShmSize   string            `yaml:"shmSize,omitempty"`
+	Ulimits   map[string]string `yaml:",omitempty"`
+}
+
+// check the resource limits
+func validateResources(resources *resourcesSpec) {
+	if resources == nil {
+		return
+	}
+	validateCpus(resources.Cpus)
+	validateMemory("memory", resources.Memory)
+	validateMemory("shmSize", resources.ShmSize)
+	if resources.PidsLimit != nil && (*resources.PidsLimit == 0 || *resources.PidsLimit < -1) {
+		logFatalf("Invalid pidsLimit %d (a number greater than 0 or -1 for unlimited)!", *resources.PidsLimit)
+	}
+	for name, value := range resources.Ulimits {
+		if _, err := units.ParseUlimit(name + "=" + value); err != nil {
+			logFatalf("Invalid ulimit \"%s\": %v", name, err)
+		}
+	}
+}
+
+// check a number of CPUs (e.g. "1.5")
+func validateCpus(cpus string) {
+	if cpus == "" {
+		return
+	}
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 {
+		logFatalf("Invalid cpus \"%s\" (a number greater than 0, e.g. 1.5)!", cpus)
+	}
+}
+
+// check a memory size (e.g. "512m")
+func validateMemory(name string, size string) {
+	if size == "" {
+		return
+	}
+	value, err := units.RAMInBytes(size)
+	if err != nil || value <= 0 {
+		logFatalf("Invalid %s \"%s\" (a size greater than 0, e.g. 512m or 2g)!", name, size)
+	}
+}
+
+// OverrideResources replaces the CPU and memory limits of the app file, empty values are ignored.
+// The overrides only apply to the container, the image hash does not change.
+func (cfg *AppInfo) OverrideResources(cpus string, memory string) {
+	validateCpus(cpus)
+	validateMemory("memory", memory)
+
+	cfg.resourceOverrides = resourcesSpec{Cpus: cpus, Memory: memory}
+}
+
+// get the docker run arguments of the resource limits
+func (cfg *AppInfo) getResourcesRunArgs() []string {
+	resources := resourcesSpec{}
+	if cfg.appConfig.Resources != nil {
+		resources = *cfg.appConfig.Resources
+	}
+	if cfg.resourceOverrides.Cpus != "" {
+		resources.Cpus = cfg.resourceOverrides.Cpus
+	}
+	if cfg.resourceOverrides.Memory != "" {
+		resources.Memory = cfg.resourceOverrides.Memory
+	}
+
+	runArgs := []string{}
+	if resources.Cpus != "" {
+		runArgs = append(runArgs, "--cpus", resources.Cpus)
+	}
+	if resources.Memory != "" {
+		runArgs = append(runArgs, "--memory", resources.Memory)
+	}
+	if resources.PidsLimit != nil {
+		runArgs = append(runArgs, "--pids-limit", strconv.Itoa(*resources.PidsLimit))
+	}
+	if resources.ShmSize != "" {
+		runArgs = append(runArgs, "--shm-size", resources.ShmSize)
+	}
+
+	names := []string{}
+	for name := range resources.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		runArgs = append(runArgs, "--ulimit", name+"="+resources.Ulimits[name])
+	}
+
+	return runArgs
+}
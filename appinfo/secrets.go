@@ -0,0 +1,114 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// specification of a build secret in an app file
+type secretSpec struct {
+	ID   string `yaml:"id"`
+	File string `yaml:",omitempty"`
+	Env  string `yaml:",omitempty"`
+}
+
+// BuildSecret is passed to the image build as BuildKit secret and never stored in the image
+type BuildSecret struct {
+	ID   string
+	File string
+	Env  string
+}
+
+// check the secret definitions
+func validateSecrets(secrets []secretSpec) {
+	ids := map[string]bool{}
+	for _, secret := range secrets {
+		if secret.ID == "" {
+			logFatalf("Secrets need an id!")
+			return
+		}
+		if ids[secret.ID] {
+			logFatalf("Secret \"%s\" is defined twice!", secret.ID)
+		}
+		ids[secret.ID] = true
+		if (secret.File == "") == (secret.Env == "") {
+			logFatalf("Secret \"%s\" needs either a file or an env!", secret.ID)
+		}
+	}
+}
+
+// GetBuildSecrets returns the secrets which are needed to build the image
+func (cfg *AppInfo) GetBuildSecrets() []BuildSecret {
+	buildSecrets := []BuildSecret{}
+	for _, secret := range cfg.appConfig.Secrets {
+		buildSecret := BuildSecret{ID: secret.ID, File: secret.File, Env: secret.Env}
+		cfg.replaceParameters(&buildSecret.File)
+		cfg.replaceParameters(&buildSecret.Env)
+		// relative to the app file
+		if buildSecret.File != "" && !filepath.IsAbs(buildSecret.File) {
+			buildSecret.File = filepath.Join(cfg.GetAppFileDir(), buildSecret.File)
+		}
+		buildSecrets = append(buildSecrets, buildSecret)
+	}
+	return buildSecrets
+}
+
+// ReadBuildSecret returns the value of a secret, secrets are only needed to build the image
+func (cfg *AppInfo) ReadBuildSecret(secret BuildSecret) []byte {
+	value, err := lookupBuildSecret(secret)
+	if err != nil {
+		logFatalf("Secret \"%s\": %v", secret.ID, err)
+	}
+	return value
+}
+
+// return the value of a secret or an error if it is not available
+func lookupBuildSecret(secret BuildSecret) ([]byte, error) {
+	if secret.Env != "" {
+		value := getEnvVar(secret.Env)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", secret.Env)
+		}
+		return []byte(value), nil
+	}
+	return afero.ReadFile(filesystem, secret.File)
+}
+
+// GetBuildSecretsDigest returns a digest over all secrets so that a changed secret can be detected,
+// the digest is keyed so that it cannot be used to guess a secret. ok is false if a secret is not available.
+func (cfg *AppInfo) GetBuildSecretsDigest(key []byte) (digest string, ok bool) {
+	secrets := cfg.GetBuildSecrets()
+	if len(secrets) == 0 {
+		return "", true
+	}
+
+	hash := hmac.New(sha256.New, key)
+	for _, secret := range secrets {
+		value, err := lookupBuildSecret(secret)
+		if err != nil {
+			return "", false
+		}
+		secretDigest := sha256.Sum256(value)
+		hash.Write([]byte(secret.ID + ":sha256:" + hex.EncodeToString(secretDigest[:])))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
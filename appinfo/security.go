@@ -0,0 +1,129 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// restrictions of an app container
+type securitySpec struct {
+	Capabilities struct {
+		Add  []string `yaml:",omitempty"`
+		Drop []string `yaml:",omitempty"`
+	} `yaml:",omitempty"`
+	ReadOnlyRootfs  *bool    `yaml:"readOnlyRootfs,omitempty"`
+	Tmpfs           []string `yaml:",omitempty"`
+	NoNewPrivileges *bool    `yaml:"noNewPrivileges,omitempty"`
+	Seccomp         string   `yaml:",omitempty"`
+	NoNetwork       bool     `yaml:"noNetwork,omitempty"`
+}
+
+// writable directories of a container with read-only root filesystem if nothing else is given
+var defaultTmpfs = []string{"/tmp"}
+
+var capabilityRegex = regexp.MustCompile(`^(ALL|(CAP_)?[A-Z_]+)$`)
+
+// check the security settings
+func validateSecurity(security *securitySpec) {
+	if security == nil {
+		return
+	}
+	capabilities := append(append([]string{}, security.Capabilities.Add...), security.Capabilities.Drop...)
+	for _, capability := range capabilities {
+		if !capabilityRegex.MatchString(capability) {
+			logFatalf("Invalid capability \"%s\" (e.g. ALL or NET_ADMIN)!", capability)
+		}
+	}
+	for _, tmpfs := range security.Tmpfs {
+		if !strings.HasPrefix(strings.SplitN(tmpfs, ":", 2)[0], "/") {
+			logFatalf("Tmpfs mount point \"%s\" must be an absolute path!", tmpfs)
+		}
+	}
+}
+
+// EnableStrictSecurity applies a hardened default (no capabilities, read-only root filesystem and
+// no privilege escalation) to all settings which are not given by the app file
+func (cfg *AppInfo) EnableStrictSecurity() {
+	cfg.strictSecurity = true
+}
+
+// get the docker run arguments of the security settings
+func (cfg *AppInfo) getSecurityRunArgs() []string {
+	security := securitySpec{}
+	if cfg.appConfig.Security != nil {
+		security = *cfg.appConfig.Security
+	}
+
+	if cfg.strictSecurity {
+		if len(security.Capabilities.Drop) == 0 {
+			security.Capabilities.Drop = []string{"ALL"}
+		}
+		if security.ReadOnlyRootfs == nil {
+			security.ReadOnlyRootfs = boolPtr(true)
+		}
+		if security.NoNewPrivileges == nil {
+			security.NoNewPrivileges = boolPtr(true)
+		}
+	}
+
+	runArgs := []string{}
+	for _, capability := range security.Capabilities.Drop {
+		runArgs = append(runArgs, "--cap-drop", capability)
+	}
+	for _, capability := range security.Capabilities.Add {
+		runArgs = append(runArgs, "--cap-add", capability)
+	}
+
+	if security.ReadOnlyRootfs != nil && *security.ReadOnlyRootfs {
+		runArgs = append(runArgs, "--read-only")
+		tmpfsMounts := security.Tmpfs
+		if len(tmpfsMounts) == 0 {
+			tmpfsMounts = defaultTmpfs
+		}
+		for _, tmpfs := range tmpfsMounts {
+			runArgs = append(runArgs, "--tmpfs", tmpfs)
+		}
+	} else {
+		for _, tmpfs := range security.Tmpfs {
+			runArgs = append(runArgs, "--tmpfs", tmpfs)
+		}
+	}
+
+	if security.NoNewPrivileges != nil && *security.NoNewPrivileges {
+		runArgs = append(runArgs, "--security-opt", "no-new-privileges")
+	}
+
+	if security.Seccomp != "" {
+		seccompProfile := security.Seccomp
+		cfg.replaceParameters(&seccompProfile)
+		if seccompProfile != "unconfined" && !filepath.IsAbs(seccompProfile) {
+			seccompProfile = filepath.Join(cfg.GetAppFileDir(), seccompProfile)
+		}
+		runArgs = append(runArgs, "--security-opt", "seccomp="+seccompProfile)
+	}
+
+	if security.NoNetwork && cfg.appConfig.Runtime.Network == "" {
+		runArgs = append(runArgs, "--network", "none")
+	}
+
+	return runArgs
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
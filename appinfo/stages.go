@@ -0,0 +1,84 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appinfo
+
+import (
+	"regexp"
+	"strings"
+)
+
+// a build stage of a multi-stage image, the last stage becomes the app image
+type stageSpec struct {
+	Name       string `yaml:",omitempty"`
+	Base       string
+	Dockerfile string
+}
+
+var stageNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+var dockerURLRegex = regexp.MustCompile("^docker://")
+
+// check the stages of a multi-stage image
+func validateStages(appInfoConfig yamlSpec) {
+	stages := appInfoConfig.Image.Stages
+	if len(stages) == 0 {
+		return
+	}
+	if appInfoConfig.Image.Base != "" || appInfoConfig.Image.Dockerfile != "" {
+		logFatalf("image.stages cannot be combined with image.base and image.dockerfile (the last stage is the app image)!")
+	}
+
+	names := map[string]bool{}
+	for i, stage := range stages {
+		if stage.Base == "" {
+			logFatalf("Stage %d has no base image!", i+1)
+		}
+		if stage.Name == "" {
+			continue
+		}
+		if !stageNameRegex.MatchString(stage.Name) {
+			logFatalf("Invalid stage name \"%s\"!", stage.Name)
+		}
+		// stage names are case-insensitive
+		name := strings.ToLower(stage.Name)
+		if names[name] {
+			logFatalf("Stage name \"%s\" is used twice!", stage.Name)
+		}
+		names[name] = true
+	}
+}
+
+// get the stages of the app image, an image without stages consists of a single unnamed stage
+func (cfg *AppInfo) getImageStages() []stageSpec {
+	if len(cfg.appConfig.Image.Stages) > 0 {
+		return cfg.appConfig.Image.Stages
+	}
+	return []stageSpec{{Base: cfg.appConfig.Image.Base, Dockerfile: cfg.appConfig.Image.Dockerfile}}
+}
+
+// get the Dockerfile of a stage, proxy settings are injected into every stage
+func (cfg *AppInfo) getStageDockerfile(stage stageSpec) string {
+	stageDockerfile := ""
+	baseImage := dockerURLRegex.ReplaceAllString(stage.Base, "FROM ")
+	if baseImage != "" {
+		if stage.Name != "" {
+			baseImage += " AS " + stage.Name
+		}
+		stageDockerfile += baseImage + "\n\n"
+	}
+
+	dockerfile := cfg.handleDockerfileLoad(stage.Dockerfile)
+
+	return stageDockerfile + cfg.resolvedParams["SET_PROXY"] + "\n" + dockerfile
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/tjeske/containerflight/core"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+var logsOptions core.LogsOptions
+var containerSelector string
+
+// psCmd represents the "ps" command
+var psCmd = &cobra.Command{
+	Use:                   "ps [APPFILE]",
+	Short:                 "List running app containers",
+	Long:                  `List running app containers, optionally only the containers of one app file`,
+	Args:                  cli.RequiresMaxArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			core.ListContainers(args[0])
+		} else {
+			core.ListContainers("")
+		}
+	},
+}
+
+// logsCmd represents the "logs" command
+var logsCmd = &cobra.Command{
+	Use:                   "logs [OPTIONS] APPFILE",
+	Short:                 "Show the output of a running app container",
+	Long:                  `Show the output of a running app container`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.Logs(args[0], logsOptions)
+	},
+}
+
+// stopCmd represents the "stop" command
+var stopCmd = &cobra.Command{
+	Use:                   "stop [OPTIONS] APPFILE",
+	Short:                 "Stop the running containers of an app file",
+	Long:                  `Stop the running containers of an app file`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.Stop(args[0], containerSelector)
+	},
+}
+
+// attachCmd represents the "attach" command
+var attachCmd = &cobra.Command{
+	Use:                   "attach [OPTIONS] APPFILE",
+	Short:                 "Attach the terminal to a running app container",
+	Long:                  `Attach the terminal to a running app container`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.Attach(args[0], containerSelector)
+	},
+}
+
+// execCmd represents the "exec" command
+var execCmd = &cobra.Command{
+	Use:   "exec [OPTIONS] APPFILE [COMMAND] [ARG...]",
+	Short: "Run a command in a running app container",
+	Long: `Run a command in a running app container with the same user and working directory mapping.
+Without a command an interactive shell is started.`,
+	Args:                  cli.RequiresMinArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.Exec(args[0], args[1:], containerSelector)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(attachCmd)
+	rootCmd.AddCommand(execCmd)
+
+	logsFlags := logsCmd.Flags()
+	logsFlags.BoolVarP(&logsOptions.Follow, "follow", "f", false, "follow the output")
+	logsFlags.StringVar(&logsOptions.Container, "container", "", "container ID or name if several containers are running")
+	execCmd.Flags().SetInterspersed(false)
+	for _, cmd := range []*cobra.Command{stopCmd, attachCmd, execCmd} {
+		cmd.Flags().StringVar(&containerSelector, "container", "", "container ID or name if several containers are running")
+	}
+}
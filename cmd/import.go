@@ -0,0 +1,66 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/tjeske/containerflight/core"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+var importOutputFile string
+
+// importCmd represents the "import" command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Create an app file from an existing container description",
+	Long:  `Create an app file from an existing container description`,
+}
+
+// importDockerfileCmd represents the "import dockerfile" command
+var importDockerfileCmd = &cobra.Command{
+	Use:                   "dockerfile [OPTIONS] PATH",
+	Short:                 "Create an app file from a Dockerfile",
+	Long:                  `Create an app file from a Dockerfile`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.ImportDockerfile(args[0], importOutputFile)
+	},
+}
+
+// importComposeCmd represents the "import compose" command
+var importComposeCmd = &cobra.Command{
+	Use:   "compose [OPTIONS] FILE SERVICE",
+	Short: "Create an app file from a compose service",
+	Long: `Create an app file from a service of a compose file.
+Relative volume paths are mapped to ${APP_FILE_DIR}, so store the app file next to the compose file.`,
+	Args:                  cli.RequiresRangeArgs(2, 2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.ImportCompose(args[0], args[1], importOutputFile)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importDockerfileCmd)
+	importCmd.AddCommand(importComposeCmd)
+	for _, cmd := range []*cobra.Command{importDockerfileCmd, importComposeCmd} {
+		flags := cmd.Flags()
+		flags.StringVarP(&importOutputFile, "output", "o", "", "write the app file to a file instead of stdout")
+	}
+}
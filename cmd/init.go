@@ -0,0 +1,44 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/tjeske/containerflight/core"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+var initTemplate string
+
+// initCmd represents the "init" command
+var initCmd = &cobra.Command{
+	Use:   "init [OPTIONS] NAME",
+	Short: "Create a new app file",
+	Long: `Create a new executable app file from a template.
+Built-in templates: cli, python, java, gui. Additional templates are loaded from
+the "containerflight/templates" directory in the user configuration directory.`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.InitAppFile(args[0], initTemplate)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	flags := initCmd.Flags()
+	flags.StringVarP(&initTemplate, "template", "t", core.DefaultTemplate, "template of the app file")
+}
@@ -0,0 +1,88 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/tjeske/containerflight/core"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+var installName string
+var installDesktop bool
+var listInstalled bool
+
+// installCmd represents the "install" command
+var installCmd = &cobra.Command{
+	Use:                   "install [OPTIONS] APPFILE",
+	Short:                 "Install an app file as a command",
+	Long:                  `Create a launcher in ~/.local/bin so that an app file can be called like any other command`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.InstallApp(args[0], installName, installDesktop)
+	},
+}
+
+// uninstallCmd represents the "uninstall" command
+var uninstallCmd = &cobra.Command{
+	Use:                   "uninstall NAME",
+	Short:                 "Uninstall an app file",
+	Long:                  `Remove the launcher of an installed app file`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.UninstallApp(args[0])
+	},
+}
+
+// updateCmd represents the "update" command
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update all installed app files",
+	Long:  `Refresh the launchers of all installed app files and rebuild their images if necessary`,
+	Args:  cli.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.UpdateInstalledApps()
+	},
+}
+
+// listCmd represents the "list" command
+var listCmd = &cobra.Command{
+	Use:                   "list --installed",
+	Short:                 "List installed app files",
+	Long:                  `List all installed app files`,
+	Args:                  cli.NoArgs,
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.ListInstalledApps()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(installCmd)
+	rootCmd.AddCommand(uninstallCmd)
+	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(listCmd)
+
+	flags := installCmd.Flags()
+	flags.StringVar(&installName, "name", "", "name of the command (default: name of the app file)")
+	flags.BoolVar(&installDesktop, "desktop", false, "create a desktop entry for the app file")
+
+	flags = listCmd.Flags()
+	flags.BoolVar(&listInstalled, "installed", false, "list installed app files")
+	listCmd.MarkFlagRequired("installed")
+}
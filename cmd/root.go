@@ -17,12 +17,17 @@ package cmd
 import (
 	"os"
 
+	"github.com/tjeske/containerflight/core"
+
 	"github.com/docker/cli/cli"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var debug bool
+var strict bool
+var progress string
+var buildArgs []string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -34,6 +39,9 @@ var rootCmd = &cobra.Command{
 		if debug == true {
 			log.SetLevel(log.DebugLevel)
 		}
+		core.SetStrictSecurity(strict)
+		core.SetBuildProgress(progress)
+		core.SetBuildArgs(buildArgs)
 	},
 }
 
@@ -44,6 +52,9 @@ func Execute() {
 
 	persistentFlags := rootCmd.PersistentFlags()
 	persistentFlags.BoolVarP(&debug, "debug", "d", false, "print out debug information")
+	persistentFlags.BoolVar(&strict, "strict", false, "drop all capabilities, use a read-only root filesystem and forbid privilege escalation unless the app file says otherwise")
+	persistentFlags.StringVar(&progress, "progress", "auto", "progress output of image builds (auto, plain, tty or quiet)")
+	persistentFlags.StringArrayVar(&buildArgs, "build-arg", nil, "set a build arg of the image build (NAME=VALUE, or NAME to take the value from the environment)")
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -21,18 +21,20 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var runOptions core.RunOptions
+
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use:   "run [OPTIONS] APPFILE",
+	Use:   "run [OPTIONS] APPFILE [ARG...]",
 	Short: "Run a containerflight app",
 	Long:  `Run a containerflight app`,
 	Args:  cli.RequiresMinArgs(1),
 	DisableFlagsInUseLine: true,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) > 1 {
-			core.Run(args[0], args[1:])
+			core.Run(args[0], args[1:], runOptions)
 		} else {
-			core.Run(args[0], []string{})
+			core.Run(args[0], []string{}, runOptions)
 		}
 
 	},
@@ -42,4 +44,17 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 	flags := runCmd.Flags()
 	flags.SetInterspersed(false)
+	flags.BoolVar(&runOptions.NoConsole, "no-console", false, "do not allocate a TTY and keep stdin closed")
+	flags.BoolVarP(&runOptions.TTY, "tty", "t", false, "allocate a TTY (default: if stdin, stdout and stderr are terminals)")
+	flags.BoolVar(&runOptions.NoTTY, "no-tty", false, "do not allocate a TTY")
+	flags.BoolVarP(&runOptions.Interactive, "interactive", "i", false, "keep stdin open")
+	flags.BoolVar(&runOptions.NoInteractive, "no-interactive", false, "keep stdin closed")
+	flags.BoolVar(&runOptions.Detach, "detach", false, "run the app in the background")
+	flags.StringVar(&runOptions.Cpus, "cpus", "", "number of CPUs (overrides the app file)")
+	flags.StringVar(&runOptions.Memory, "memory", "", "memory limit, e.g. 2g (overrides the app file)")
+	flags.StringArrayVarP(&runOptions.Env, "env", "e", []string{}, "set an environment variable")
+	flags.StringArrayVarP(&runOptions.Volumes, "volume", "v", []string{}, "bind mount a volume")
+	flags.StringArrayVar(&runOptions.RunArgs, "runarg", []string{}, "pass an additional argument to \"docker run\"")
+	flags.StringVar(&runOptions.Entrypoint, "entrypoint", "", "overwrite the entrypoint of the image")
+	flags.StringVarP(&runOptions.Workdir, "workdir", "w", "", "working directory inside the container")
 }
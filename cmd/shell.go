@@ -0,0 +1,44 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/tjeske/containerflight/core"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+var shellOptions core.ShellOptions
+
+// shellCmd represents the "shell" command
+var shellCmd = &cobra.Command{
+	Use:   "shell [OPTIONS] APPFILE",
+	Short: "Open a shell in the container of an app",
+	Long: `Open an interactive shell in the container of an app for debugging.
+Mounts, user and environment are the same as for "containerflight run".`,
+	Args:                  cli.RequiresRangeArgs(1, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		core.Shell(args[0], shellOptions)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+	flags := shellCmd.Flags()
+	flags.BoolVar(&shellOptions.Root, "root", false, "start the shell as root")
+	flags.StringVar(&shellOptions.Shell, "shell", "", "shell executable (default: bash if available, otherwise sh)")
+}
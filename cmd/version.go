@@ -18,19 +18,26 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/tjeske/containerflight/core"
 	"github.com/tjeske/containerflight/version"
 )
 
+var verboseVersion bool
+
 // versionCmd shows the version of containerflight
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version",
-	Long:  `Show version`,
+	Long:  `Show version, with --verbose also the Docker API version and the version of the Docker daemon`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println(version.ContainerFlightVersion().String())
+		if verboseVersion {
+			core.PrintDockerVersion()
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(versionCmd)
+	versionCmd.Flags().BoolVarP(&verboseVersion, "verbose", "v", false, "show Docker API and daemon version")
 }
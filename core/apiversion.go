@@ -0,0 +1,100 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types/versions"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// oldest Docker API version which is supported by containerflight (Docker 1.13)
+const minAPIVersion = "1.25"
+
+// Docker API versions which are needed by optional features
+const (
+	// BuildKit with build secrets and cache mounts (Docker 18.09)
+	buildKitAPIVersion = "1.39"
+	// "RUN --mount" in the built-in Dockerfile frontend of BuildKit (Docker 20.10)
+	builtinMountsAPIVersion = "1.41"
+	// working directory of "docker exec" (Docker 17.12)
+	execWorkdirAPIVersion = "1.35"
+)
+
+// supportsAPIVersion returns true if the negotiated Docker API version is at least the given version
+func (dc *DockerClient) supportsAPIVersion(apiVersion string) bool {
+	return versions.GreaterThanOrEqualTo(dc.client.ClientVersion(), apiVersion)
+}
+
+// checkAPIVersion makes sure that the Docker daemon supports containerflight and all features
+// which are required by the app file
+func (dc *DockerClient) checkAPIVersion() {
+	apiVersion := dc.client.ClientVersion()
+	if !dc.supportsAPIVersion(minAPIVersion) {
+		logFatalf("ERROR: The Docker daemon supports API %s but containerflight needs at least API %s (Docker 1.13)!",
+			apiVersion, minAPIVersion)
+		return
+	}
+	if dc.appInfo == nil {
+		return
+	}
+
+	if len(dc.appInfo.GetBuildSecrets()) > 0 && !dc.supportsAPIVersion(buildKitAPIVersion) {
+		logFatalf("ERROR: Build secrets need BuildKit which requires Docker API %s (Docker 18.09), "+
+			"the Docker daemon supports API %s!", buildKitAPIVersion, apiVersion)
+	}
+}
+
+// supportsPersistentMode returns true if invocations can be executed in a persistent container,
+// otherwise a new container is used for each invocation
+func (dc *DockerClient) supportsPersistentMode() bool {
+	// temporary resources of a run are removed when containerflight exits, a persistent container
+	// would outlive them
+	if dc.appInfo.NeedsTemporaryResources() {
+		log.Warn("The persistent runtime mode cannot be used with the X server or a D-Bus proxy. " +
+			"The app is run in a new container.")
+		return false
+	}
+	if dc.supportsAPIVersion(execWorkdirAPIVersion) {
+		return true
+	}
+	log.Warnf("The persistent runtime mode requires Docker API %s (Docker 17.12), the Docker daemon supports API %s. "+
+		"The app is run in a new container.", execWorkdirAPIVersion, dc.client.ClientVersion())
+	return false
+}
+
+// PrintDockerVersion shows the negotiated Docker API version and the version of the Docker daemon
+func PrintDockerVersion() {
+
+	dockerClient := NewDockerClient(nil)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "Docker API version:\t"+dockerClient.client.ClientVersion()+
+		" (containerflight supports "+minAPIVersion+" - "+api.DefaultVersion+")")
+
+	serverVersion, err := dockerClient.client.ServerVersion(context.Background())
+	if err != nil {
+		fmt.Fprintln(writer, "Docker daemon:\tnot reachable ("+err.Error()+")")
+	} else {
+		fmt.Fprintln(writer, "Docker daemon:\t"+serverVersion.Version+
+			" (API "+serverVersion.MinAPIVersion+" - "+serverVersion.APIVersion+", "+serverVersion.Os+"/"+serverVersion.Arch+")")
+	}
+	writer.Flush()
+}
@@ -0,0 +1,68 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+)
+
+func TestCheckAPIVersion(t *testing.T) {
+	appConfigStr := "secrets:\n    - id: token\n      env: TOKEN"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	dockerClient.checkAPIVersion()
+
+	// build secrets need BuildKit
+	httpApiClient.apiVersion = "1.38"
+	testForLogFatal(t, func() { dockerClient.checkAPIVersion() })
+
+	// daemon is too old for containerflight
+	httpApiClient.apiVersion = "1.24"
+	dockerClient.appInfo = nil
+	testForLogFatal(t, func() { dockerClient.checkAPIVersion() })
+}
+
+func TestSupportsPersistentMode(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    mode: persistent")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	assert.True(t, dockerClient.supportsPersistentMode())
+
+	httpApiClient.apiVersion = "1.34"
+	assert.False(t, dockerClient.supportsPersistentMode())
+
+	// a D-Bus proxy would be stopped while the container is running
+	appConfigStr := "dbus:\n    bus: session\n    talk: [org.freedesktop.Notifications]\nruntime:\n    mode: persistent"
+	dockerClient = newDockerClient(appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr))
+	assert.False(t, dockerClient.supportsPersistentMode())
+}
+
+func TestGetExecConfigOldAPIVersion(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	dockerClient := newDockerClient(appInfo)
+	dockerClient.client.(*mockHttpApiClient).apiVersion = "1.34"
+
+	// the working directory of an exec cannot be set
+	container := newAppContainer("aaa111", "first", "/testAppFile")
+	container.Mounts = []types.MountPoint{{Destination: "/myworkingdir"}}
+	assert.Equal(t, "", dockerClient.getExecConfig(container, []string{"make"}).WorkingDir)
+}
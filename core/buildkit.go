@@ -0,0 +1,118 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// networks of a BuildKit build
+const (
+	networkDefault = "default"
+	networkBridge  = "bridge"
+	networkNone    = "none"
+	networkHost    = "host"
+)
+
+// progress output of an image build
+const (
+	// tty if the output is a terminal, otherwise plain
+	progressAuto = "auto"
+	// one line per build step
+	progressPlain = "plain"
+	// build steps are updated in place
+	progressTTY = "tty"
+	// nothing but errors
+	progressQuiet = "quiet"
+)
+
+// progress output of all image builds
+var buildProgress = progressAuto
+
+// SetBuildProgress selects the progress output of image builds (auto, plain, tty or quiet)
+func SetBuildProgress(progress string) {
+	switch progress {
+	case progressAuto, progressPlain, progressTTY, progressQuiet:
+		buildProgress = progress
+	default:
+		log.Fatal("ERROR: Invalid progress output \"" + progress + "\" (auto, plain, tty or quiet)!")
+	}
+}
+
+// useBuildKit returns true if images are built with BuildKit, DOCKER_BUILDKIT enforces or
+// disables BuildKit unless it is needed for build secrets
+func (dc *DockerClient) useBuildKit() bool {
+	// BuildKit cannot run "RUN" instructions in a named network
+	buildNetwork := dc.appInfo.GetBuildNetwork()
+	networkSupported := isBuildKitNetwork(buildNetwork)
+
+	if len(dc.appInfo.GetBuildSecrets()) > 0 {
+		if !networkSupported {
+			logFatalf("ERROR: Build secrets need BuildKit which cannot build in the network \"%s\" "+
+				"(default, bridge, none or host)!", buildNetwork)
+		}
+		return true
+	}
+
+	if value, ok := os.LookupEnv("DOCKER_BUILDKIT"); ok {
+		enabled, err := strconv.ParseBool(value)
+		if err == nil {
+			if enabled && !networkSupported {
+				logFatalf("ERROR: BuildKit cannot build in the network \"%s\" (default, bridge, none or host)!", buildNetwork)
+			}
+			return enabled
+		}
+		log.Warn("invalid DOCKER_BUILDKIT value \"" + value + "\"")
+	}
+
+	if !networkSupported {
+		log.Debug("BuildKit cannot build in the network \"" + buildNetwork + "\", use legacy builder")
+		return false
+	}
+
+	if !dc.supportsAPIVersion(buildKitAPIVersion) {
+		log.Debug("BuildKit requires Docker API " + buildKitAPIVersion + ", use legacy builder")
+		return false
+	}
+
+	// Windows daemons have no BuildKit support
+	serverVersion, err := dc.client.ServerVersion(context.Background())
+	if err != nil || serverVersion.Os != "linux" {
+		log.Debug("BuildKit is not available, use legacy builder")
+		return false
+	}
+	return true
+}
+
+// return true if BuildKit supports a build network, it only knows the default network, "none" and "host"
+func isBuildKitNetwork(network string) bool {
+	switch network {
+	case "", networkDefault, networkBridge, networkNone, networkHost:
+		return true
+	}
+	return false
+}
+
+// get the progress arguments of a BuildKit build with the docker cli
+func getBuildProgressArgs() []string {
+	if buildProgress == progressQuiet {
+		return []string{"--quiet"}
+	}
+	return []string{"--progress", buildProgress}
+}
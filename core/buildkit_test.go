@@ -0,0 +1,124 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+)
+
+// set DOCKER_BUILDKIT for a test, an empty value unsets it
+func setDockerBuildKitEnv(value string) (restore func()) {
+	origValue, ok := os.LookupEnv("DOCKER_BUILDKIT")
+	if value == "" {
+		os.Unsetenv("DOCKER_BUILDKIT")
+	} else {
+		os.Setenv("DOCKER_BUILDKIT", value)
+	}
+	return func() {
+		if ok {
+			os.Setenv("DOCKER_BUILDKIT", origValue)
+		} else {
+			os.Unsetenv("DOCKER_BUILDKIT")
+		}
+	}
+}
+
+func TestUseBuildKit(t *testing.T) {
+	defer setDockerBuildKitEnv("")()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	assert.True(t, dockerClient.useBuildKit())
+
+	// daemon is too old
+	httpApiClient.apiVersion = "1.38"
+	assert.False(t, dockerClient.useBuildKit())
+
+	// enforced by the environment
+	os.Setenv("DOCKER_BUILDKIT", "1")
+	assert.True(t, dockerClient.useBuildKit())
+
+	// disabled by the environment
+	httpApiClient.apiVersion = "1.40"
+	os.Setenv("DOCKER_BUILDKIT", "0")
+	assert.False(t, dockerClient.useBuildKit())
+}
+
+func TestUseBuildKitSecrets(t *testing.T) {
+	defer setDockerBuildKitEnv("0")()
+
+	appConfigStr := "secrets:\n    - id: token\n      env: TOKEN"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	assert.True(t, dockerClient.useBuildKit())
+}
+
+func TestUseBuildKitNetwork(t *testing.T) {
+	defer setDockerBuildKitEnv("")()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "image:\n    network: none")
+	assert.True(t, newDockerClient(appInfo).useBuildKit())
+
+	// named networks are built with the legacy builder
+	appInfo = appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "image:\n    network: mynet")
+	dockerClient := newDockerClient(appInfo)
+	assert.False(t, dockerClient.useBuildKit())
+
+	os.Setenv("DOCKER_BUILDKIT", "1")
+	testForLogFatal(t, func() { dockerClient.useBuildKit() })
+
+	appConfigStr := "image:\n    network: mynet\nsecrets:\n    - id: token\n      env: TOKEN"
+	appInfo = appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient = newDockerClient(appInfo)
+	testForLogFatal(t, func() { dockerClient.useBuildKit() })
+}
+
+func TestGetBuildProgressArgs(t *testing.T) {
+	defer SetBuildProgress(progressAuto)
+
+	assert.Equal(t, []string{"--progress", "auto"}, getBuildProgressArgs())
+
+	SetBuildProgress(progressPlain)
+	assert.Equal(t, []string{"--progress", "plain"}, getBuildProgressArgs())
+
+	SetBuildProgress(progressQuiet)
+	assert.Equal(t, []string{"--quiet"}, getBuildProgressArgs())
+}
+
+func TestBuildImageQuiet(t *testing.T) {
+	defer SetBuildProgress(progressAuto)
+	SetBuildProgress(progressQuiet)
+
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	err := dockerClient.buildImage("/buildctx", "containerflight_test:1.0", "hashStr")
+	util.CheckErr(err)
+
+	assert.True(t, httpApiClient.buildOptions.SuppressOutput)
+	assert.Equal(t, "", stdoutBuffer.String())
+}
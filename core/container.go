@@ -0,0 +1,215 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+	"golang.org/x/net/context"
+)
+
+// "mock connectors" for unit-tesing
+var logFatalf = log.Fatalf
+
+// LogsOptions contains command line options of "containerflight logs"
+type LogsOptions struct {
+	Follow    bool
+	Container string
+}
+
+// ListContainers shows all running app containers, optionally only the containers of one app file
+func ListContainers(yamlAppConfigFileName string) {
+
+	appConfigFile := ""
+	if yamlAppConfigFileName != "" {
+		appConfigFile = appinfo.NewAppInfo(yamlAppConfigFileName).GetAppConfigFile()
+	}
+	dockerClient := NewDockerClient(nil)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "CONTAINER ID\tNAME\tAPP FILE\tSTATUS")
+	for _, container := range dockerClient.getAppContainers(appConfigFile) {
+		fmt.Fprintln(writer, shortContainerID(container.ID)+"\t"+getContainerName(container)+"\t"+
+			container.Labels["containerflight_appFile"]+"\t"+container.Status)
+	}
+	writer.Flush()
+}
+
+// Logs shows the output of a running app container
+func Logs(yamlAppConfigFileName string, options LogsOptions) {
+
+	dockerClient := NewDockerClient(appinfo.NewAppInfo(yamlAppConfigFileName))
+	container := dockerClient.findAppContainer(options.Container)
+
+	err := dockerClient.showLogs(container.ID, options.Follow)
+	util.CheckErr(err)
+}
+
+// Attach connects the terminal to a running app container
+func Attach(yamlAppConfigFileName string, containerSelector string) {
+
+	dockerClient := NewDockerClient(appinfo.NewAppInfo(yamlAppConfigFileName))
+	container := dockerClient.findAppContainer(containerSelector)
+
+	statusCode, err := dockerClient.attachContainer(container.ID)
+	util.CheckErr(err)
+	if statusCode != 0 {
+		os.Exit(statusCode)
+	}
+}
+
+// Stop stops the running containers of an app file, the containers are removed afterwards
+func Stop(yamlAppConfigFileName string, containerSelector string) {
+
+	dockerClient := NewDockerClient(appinfo.NewAppInfo(yamlAppConfigFileName))
+
+	containers := dockerClient.getAppContainers(dockerClient.appInfo.GetAppConfigFile())
+	if containerSelector != "" {
+		containers = []types.Container{dockerClient.findAppContainer(containerSelector)}
+	}
+	if len(containers) == 0 {
+		fmt.Println("no running container of \"" + dockerClient.appInfo.GetAppConfigFile() + "\"")
+		return
+	}
+
+	containerIDs := []string{}
+	for _, container := range containers {
+		containerIDs = append(containerIDs, container.ID)
+	}
+	err := dockerClient.stopContainers(containerIDs)
+	util.CheckErr(err)
+}
+
+// Exec runs a command in a running app container, by default an interactive shell
+func Exec(yamlAppConfigFileName string, args []string, containerSelector string) {
+
+	dockerClient := NewDockerClient(appinfo.NewAppInfo(yamlAppConfigFileName))
+	container := dockerClient.findAppContainer(containerSelector)
+
+	statusCode, err := dockerClient.executeExec(container.ID, dockerClient.getExecConfig(container, args))
+	util.CheckErr(err)
+	if statusCode != 0 {
+		os.Exit(statusCode)
+	}
+}
+
+// get the Engine API configuration of an exec, it runs as the user of the container and in the current
+// working directory if it is mounted into the container
+func (dc *DockerClient) getExecConfig(container types.Container, args []string) types.ExecConfig {
+	config := dc.newExecConfig()
+
+	inspect, err := dc.client.ContainerInspect(context.Background(), container.ID)
+	util.CheckErr(err)
+	config.User = inspect.Config.User
+
+	workingDir := util.GetUnixFilePath(util.GetWorkingDir())
+	for _, mount := range container.Mounts {
+		if workingDir == mount.Destination || strings.HasPrefix(workingDir, strings.TrimSuffix(mount.Destination, "/")+"/") {
+			if dc.supportsAPIVersion(execWorkdirAPIVersion) {
+				config.WorkingDir = workingDir
+			} else {
+				log.Warnf("The command is not run in \"%s\" which requires Docker API %s!", workingDir, execWorkdirAPIVersion)
+			}
+			break
+		}
+	}
+
+	config.Cmd = args
+	if len(args) == 0 {
+		config.Cmd = []string{"/bin/sh", "-c", defaultShellCmd}
+	}
+	return config
+}
+
+// create an exec configuration with the console settings of the app
+func (dc *DockerClient) newExecConfig() types.ExecConfig {
+	return types.ExecConfig{
+		Tty:          dc.appInfo.HasTTY(),
+		AttachStdin:  dc.appInfo.IsInteractive(),
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+}
+
+// getAppContainers returns the running containers of an app file (all app containers if no app file is given)
+func (dc *DockerClient) getAppContainers(appConfigFile string) []types.Container {
+	label := "containerflight_appFile"
+	if appConfigFile != "" {
+		label += "=" + appConfigFile
+	}
+	options := types.ContainerListOptions{Filters: filters.NewArgs(filters.Arg("label", label))}
+	containers, err := dc.client.ContainerList(context.Background(), options)
+	util.CheckErr(err)
+	return containers
+}
+
+// findAppContainer returns the only running container of the app file, a selector (container ID prefix
+// or name) is needed if several containers are running
+func (dc *DockerClient) findAppContainer(containerSelector string) types.Container {
+	appConfigFile := dc.appInfo.GetAppConfigFile()
+
+	matches := []types.Container{}
+	for _, container := range dc.getAppContainers(appConfigFile) {
+		if containerSelector == "" ||
+			strings.HasPrefix(container.ID, containerSelector) ||
+			getContainerName(container) == containerSelector {
+			matches = append(matches, container)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		if containerSelector != "" {
+			logFatalf("ERROR: No running container \"%s\" of \"%s\"!", containerSelector, appConfigFile)
+		} else {
+			logFatalf("ERROR: No running container of \"%s\"!", appConfigFile)
+		}
+		return types.Container{}
+	case 1:
+		return matches[0]
+	}
+
+	names := []string{}
+	for _, container := range matches {
+		names = append(names, getContainerName(container))
+	}
+	logFatalf("ERROR: Several containers of \"%s\" are running (%s), select one with --container!",
+		appConfigFile, strings.Join(names, ", "))
+	return types.Container{}
+}
+
+// return the name of a container without leading slash
+func getContainerName(container types.Container) string {
+	if len(container.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+// return the abbreviated container ID as shown by "docker ps"
+func shortContainerID(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
@@ -15,19 +15,26 @@
 package core
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/docker/cli/cli/command"
-	cmd_container "github.com/docker/cli/cli/command/container"
 	cmd_build "github.com/docker/cli/cli/command/image"
 	cliflags "github.com/docker/cli/cli/flags"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
@@ -44,37 +51,59 @@ var containerflightVersion = version.ContainerFlightVersion().String()
 type dockerHttpApiClient interface {
 	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
 	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
-}
-
-type dockerCliClient interface {
-	command.Cli
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	ContainerKill(ctx context.Context, container, signal string) error
+	ContainerResize(ctx context.Context, container string, options types.ResizeOptions) error
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error)
+	ContainerAttach(ctx context.Context, container string, options types.ContainerAttachOptions) (types.HijackedResponse, error)
+	ContainerStart(ctx context.Context, container string, options types.ContainerStartOptions) error
+	ContainerWait(ctx context.Context, container string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error)
+	ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+	ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	ContainerStop(ctx context.Context, container string, timeout *time.Duration) error
+	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error
+	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error)
+	ContainerExecResize(ctx context.Context, execID string, options types.ResizeOptions) error
+	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	ClientVersion() string
 }
 
 // DockerClient abstracts the containerflight communication with a moby daemon
 type DockerClient struct {
-	appInfo   *appinfo.AppInfo
-	client    dockerHttpApiClient
-	dockerCli dockerCliClient
+	appInfo *appinfo.AppInfo
+	client  dockerHttpApiClient
 }
 
 var notWordChar = regexp.MustCompile("\\W")
 
-// NewDockerClient creates a new Docker client using API 1.25
+// NewDockerClient creates a new Docker client, the API version is negotiated with the daemon
+// unless it is set by DOCKER_API_VERSION
 func NewDockerClient(appInfo *appinfo.AppInfo) *DockerClient {
-	os.Setenv("DOCKER_API_VERSION", "1.25")
 
 	// Docker HTTP API client
 	client, err := client.NewClientWithOpts(client.FromEnv)
 	util.CheckErr(err)
+	client.NegotiateAPIVersion(context.Background())
+	log.Debug("use Docker API " + client.ClientVersion())
 
-	// Docker cli client
-	dockerCli, err := command.NewDockerCli(command.WithStandardStreams())
+	dockerClient := &DockerClient{appInfo: appInfo, client: client}
+	dockerClient.checkAPIVersion()
+	return dockerClient
+}
+
+// create a Docker cli client with the given streams, it is only needed for BuildKit builds
+func newDockerCli(streams ...command.DockerCliOption) command.Cli {
+	dockerCli, err := command.NewDockerCli(streams...)
 	util.CheckErr(err)
 	opts := cliflags.NewClientOptions()
 	err = dockerCli.Initialize(opts)
 	util.CheckErr(err)
-
-	return &DockerClient{appInfo: appInfo, client: client, dockerCli: dockerCli}
+	return dockerCli
 }
 
 // build a Docker container
@@ -83,12 +112,41 @@ func (dc *DockerClient) build(dockerBuildCtx string, label string, hashStr strin
 	// remove all previous images
 	dc.removeImages(label)
 
+	// a BuildKit build needs a session which is only provided by the docker cli
+	if dc.useBuildKit() {
+		dc.appInfo.EnableBuildKit(dc.supportsAPIVersion(builtinMountsAPIVersion))
+		dc.buildWithBuildKit(dockerBuildCtx, label, hashStr)
+		return
+	}
+
+	err := dc.buildImage(dockerBuildCtx, label, hashStr)
+	util.CheckErr(err)
+}
+
+// build a Docker container with BuildKit through the docker cli
+func (dc *DockerClient) buildWithBuildKit(dockerBuildCtx string, label string, hashStr string) {
+
 	// create temporary Dockerfile
 	tmpDockerFile := dc.createTempDockerFile(dockerBuildCtx, label)
 	defer filesystem.Remove(tmpDockerFile.Name())
 
-	cmdDockerRun := cmd_build.NewBuildCommand(dc.dockerCli)
+	secretArgs, removeSecretFiles := dc.getBuildSecretArgs()
+	defer removeSecretFiles()
+	os.Setenv("DOCKER_BUILDKIT", "1")
+
+	// a quiet build prints the image ID which is not of interest
+	var dockerCli command.Cli
+	if buildProgress == progressQuiet {
+		dockerCli = newDockerCli(command.WithInputStream(os.Stdin), command.WithOutputStream(ioutil.Discard), command.WithErrorStream(os.Stderr))
+	} else {
+		dockerCli = newDockerCli(command.WithStandardStreams())
+	}
+
+	cmdDockerRun := cmd_build.NewBuildCommand(dockerCli)
 	buildCmdArgs := dc.getBuildCmdArgs(tmpDockerFile.Name(), dockerBuildCtx, label, hashStr)
+	buildCmdArgs = append(buildCmdArgs, secretArgs...)
+	buildCmdArgs = append(buildCmdArgs, dc.getBuildArgCmdArgs()...)
+	buildCmdArgs = append(buildCmdArgs, getBuildProgressArgs()...)
 	cmdDockerRun.SetArgs(buildCmdArgs)
 	cmdDockerRun.SilenceErrors = true
 	cmdDockerRun.SilenceUsage = true
@@ -137,37 +195,116 @@ func (dc *DockerClient) createTempDockerFile(dockerBuildCtx string, label string
 	return tmpDockerFile
 }
 
+// get the "--secret" build args, secrets from environment variables are stored in temporary files
+// which must be removed after the build
+func (dc *DockerClient) getBuildSecretArgs() (secretArgs []string, removeSecretFiles func()) {
+	secretFiles := []string{}
+	removeSecretFiles = func() {
+		for _, secretFile := range secretFiles {
+			filesystem.Remove(secretFile)
+		}
+	}
+
+	for _, secret := range dc.appInfo.GetBuildSecrets() {
+		secretFile := secret.File
+		if secret.Env != "" {
+			tmpSecretFile, err := afero.TempFile(filesystem, "", "containerflight-secret")
+			util.CheckErr(err)
+			secretFiles = append(secretFiles, tmpSecretFile.Name())
+			_, err = tmpSecretFile.Write(dc.appInfo.ReadBuildSecret(secret))
+			tmpSecretFile.Close()
+			util.CheckErr(err)
+			secretFile = tmpSecretFile.Name()
+		}
+		secretArgs = append(secretArgs, "--secret", "id="+secret.ID+",src="+secretFile)
+	}
+
+	return secretArgs, removeSecretFiles
+}
+
+// get the "--build-arg" build args sorted by name
+func (dc *DockerClient) getBuildArgCmdArgs() []string {
+	buildArgs := dc.appInfo.GetBuildArgs()
+	names := []string{}
+	for name := range buildArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buildArgCmdArgs := []string{}
+	for _, name := range names {
+		buildArgCmdArgs = append(buildArgCmdArgs, "--build-arg", name+"="+buildArgs[name])
+	}
+	return buildArgCmdArgs
+}
+
 // get Docker build command args
 func (dc *DockerClient) getBuildCmdArgs(dockerfile string, dockerBuildCtx string, label string, hashStr string) []string {
-	description := dc.appInfo.GetAppDescription()
-
-	buildCmd := []string{
-		dockerBuildCtx,
-		"-f", dockerfile,
-		"--label", "containerflight=true",
-		"--label", "containerflight_appFile=" + dc.appInfo.GetAppConfigFile(),
-		"--label", "containerflight_hash=" + hashStr,
-		"--label", "containerflight_cfVersion=" + containerflightVersion,
-		"--label", "containerflight_description=" + description,
-		"-t", label,
+	buildCmd := []string{dockerBuildCtx, "-f", dockerfile}
+	for _, imageLabel := range dc.getImageLabels(hashStr) {
+		buildCmd = append(buildCmd, "--label", imageLabel)
+	}
+	buildCmd = append(buildCmd, "-t", label)
+
+	// the bridge network of the legacy builder is the default network of BuildKit
+	if buildNetwork := dc.appInfo.GetBuildNetwork(); buildNetwork == networkBridge {
+		buildCmd = append(buildCmd, "--network", networkDefault)
+	} else if buildNetwork != "" {
+		buildCmd = append(buildCmd, "--network", buildNetwork)
 	}
 
 	return buildCmd
 }
 
+// get the labels of an app image
+func (dc *DockerClient) getImageLabels(hashStr string) []string {
+	labels := []string{
+		"containerflight=true",
+		"containerflight_appFile=" + dc.appInfo.GetAppConfigFile(),
+		"containerflight_hash=" + hashStr,
+		"containerflight_cfVersion=" + containerflightVersion,
+		"containerflight_description=" + dc.appInfo.GetAppDescription(),
+	}
+	if secretsDigest, _ := dc.getBuildSecretsDigest(); secretsDigest != "" {
+		labels = append(labels, "containerflight_secretsDigest="+secretsDigest)
+	}
+	return labels
+}
+
 // run a Docker container
 func (dc *DockerClient) run(args []string) {
-	cmdDockerRun := cmd_container.NewRunCommand(dc.dockerCli)
+	if !dc.checkDetachedRun() {
+		return
+	}
 	imageID := dc.getImageID()
-	dockerRunCmdArgs := dc.getRunCmdArgs(imageID, args)
-	cmdDockerRun.SetArgs(dockerRunCmdArgs)
-	cmdDockerRun.SilenceErrors = true
-	cmdDockerRun.SilenceUsage = true
-
-	log.Debug("execute \"docker run " + strings.Join(dockerRunCmdArgs, " ") + "\"")
 
-	err := cmdDockerRun.Execute()
+	// containerflight forwards signals itself so that they reach the container also without TTY
+	runID := newRunID()
+	dockerRunCmdArgs := append(
+		[]string{"--label", "containerflight_run=" + runID, "--sig-proxy=false"},
+		dc.getRunCmdArgs(imageID, args)...,
+	)
+
+	proxy := newSignalProxy(runTarget{dc.client, runID}, dc.appInfo.HasTTY())
+	proxy.start()
+	statusCode, err := dc.executeRun(dockerRunCmdArgs)
+	proxy.stop()
+	dc.appInfo.Cleanup()
 	util.CheckErr(err)
+	if statusCode != 0 {
+		os.Exit(statusCode)
+	}
+}
+
+// temporary resources of a run are removed when containerflight exits, so a detached container
+// must not depend on them
+func (dc *DockerClient) checkDetachedRun() bool {
+	if !dc.appInfo.IsDetached() || !dc.appInfo.NeedsTemporaryResources() {
+		return true
+	}
+	logFatalf("ERROR: \"%s\" cannot be run detached, it uses the X server or a D-Bus proxy which need temporary "+
+		"resources of containerflight (use \"gui: wayland\" or \"dbus\" without \"talk\")!", dc.appInfo.GetAppConfigFile())
+	return false
 }
 
 // return Docker image Id, if image does not exists build it
@@ -198,13 +335,16 @@ func (dc *DockerClient) getRunCmdArgs(imageID string, args []string) []string {
 	containerLabel := dc.getDockerContainerLabel()
 	hashStr := dc.getDockerContainerHash()
 
-	runCmdArgs := []string{
-		"--rm",
-		"--label", "containerflight_appFile=" + appConfigFile,
-		"--label", "containerflight_image=" + containerLabel,
-		"--label", "containerflight_hash=" + hashStr,
-		"--label", "containerflight_version=" + containerflightVersion,
+	runCmdArgs := []string{"--rm"}
+	if appInfo.UseInit() {
+		runCmdArgs = append(runCmdArgs, "--init")
 	}
+	runCmdArgs = append(runCmdArgs,
+		"--label", "containerflight_appFile="+appConfigFile,
+		"--label", "containerflight_image="+containerLabel,
+		"--label", "containerflight_hash="+hashStr,
+		"--label", "containerflight_version="+containerflightVersion,
+	)
 
 	runCmdArgs = append(runCmdArgs, dockerRunArgs...)
 	runCmdArgs = append(runCmdArgs, imageID)
@@ -213,6 +353,14 @@ func (dc *DockerClient) getRunCmdArgs(imageID string, args []string) []string {
 	return runCmdArgs
 }
 
+// getAppImages returns all Docker images which have been built by containerflight
+func (dc *DockerClient) getAppImages() []types.ImageSummary {
+	options := types.ImageListOptions{Filters: filters.NewArgs(filters.Arg("label", "containerflight=true"))}
+	images, err := dc.client.ImageList(context.Background(), options)
+	util.CheckErr(err)
+	return images
+}
+
 // getDockerContainerImageID returns the Docker image ID for an app hash value
 func (dc *DockerClient) getDockerContainerImageID(hashStr string) (string, error) {
 	images, err := dc.client.ImageList(context.Background(), types.ImageListOptions{})
@@ -247,8 +395,58 @@ func (dc *DockerClient) getDockerContainerLabel() string {
 	return label
 }
 
+// get the digest of the build secrets, it is keyed with a random key of the user because it is
+// stored in an image label
+func (dc *DockerClient) getBuildSecretsDigest() (digest string, ok bool) {
+	if len(dc.appInfo.GetBuildSecrets()) == 0 {
+		return "", true
+	}
+	return dc.appInfo.GetBuildSecretsDigest(getSecretsDigestKey())
+}
+
+// return the key of the secrets digests, it is created on first use
+func getSecretsDigestKey() []byte {
+	keyFile := filepath.Join(getConfigDir(), "secrets.key")
+	key, err := afero.ReadFile(filesystem, keyFile)
+	if err == nil {
+		return key
+	}
+
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	util.CheckErr(err)
+	err = filesystem.MkdirAll(getConfigDir(), 0755)
+	util.CheckErr(err)
+	err = afero.WriteFile(filesystem, keyFile, key, 0600)
+	util.CheckErr(err)
+	return key
+}
+
 // get the corresponding hash value for an app file
 func (dc *DockerClient) getDockerContainerHash() string {
+	secretsDigest, ok := dc.getBuildSecretsDigest()
+	if ok {
+		return dc.computeDockerContainerHash(secretsDigest)
+	}
+
+	// secrets are only needed to build the image, an existing image is found by its secrets digest
+	appConfigFile := dc.appInfo.GetAppConfigFile()
+	for _, image := range dc.getAppImages() {
+		imageSecretsDigest := image.Labels["containerflight_secretsDigest"]
+		if imageSecretsDigest == "" || image.Labels["containerflight_appFile"] != appConfigFile {
+			continue
+		}
+		if hashStr := dc.computeDockerContainerHash(imageSecretsDigest); hashStr == image.Labels["containerflight_hash"] {
+			return hashStr
+		}
+	}
+
+	// there is no image, the build fails because of the missing secret
+	return dc.computeDockerContainerHash("")
+}
+
+// compute the hash value of an app file with the given digest of its secrets
+func (dc *DockerClient) computeDockerContainerHash(secretsDigest string) string {
 
 	appConfigStr := dc.appInfo.GetResolvedAppConfig()
 
@@ -261,6 +459,14 @@ func (dc *DockerClient) getDockerContainerHash() string {
 	// hash containerflight version
 	hash.Write([]byte(containerflightVersion))
 
+	// hash digest of secrets (values must not be part of the app config)
+	hash.Write([]byte(secretsDigest))
+
+	// hash build args (see GetHashedBuildArgs for the rules)
+	for _, buildArg := range dc.appInfo.GetHashedBuildArgs() {
+		hash.Write([]byte(buildArg))
+	}
+
 	// hash Docker build context if relevant
 	dockerBuildCtx := dc.appInfo.GetAppFileDir()
 	if dc.isContextUsed() {
@@ -305,6 +511,10 @@ func (dc *DockerClient) isContextUsed() (isUsed bool) {
 	isUsed = false
 	for _, dockerfileLine := range dockerfileLines {
 		linePreProcessed := strings.ToUpper(strings.TrimSpace(dockerfileLine))
+		// "COPY --from=<stage>" copies from another build stage
+		if strings.HasPrefix(linePreProcessed, "COPY --FROM=") {
+			continue
+		}
 		if strings.HasPrefix(linePreProcessed, "COPY ") || strings.HasPrefix(linePreProcessed, "ADD ") {
 			isUsed = true
 			break
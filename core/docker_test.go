@@ -15,12 +15,23 @@
 package core
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"os"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/tjeske/containerflight/appinfo"
@@ -29,7 +40,23 @@ import (
 )
 
 type mockHttpApiClient struct {
-	imageRepo []types.ImageSummary
+	imageRepo  []types.ImageSummary
+	containers []types.Container
+	calls      []string
+	apiVersion string
+
+	// run and build of a container
+	config       *container.Config
+	hostConfig   *container.HostConfig
+	output       []byte
+	statusCode   int64
+	buildContext []byte
+	buildOptions types.ImageBuildOptions
+
+	// exec in a running container
+	execConfig       types.ExecConfig
+	execCmds         [][]string
+	containerConfigs map[string]*container.Config
 }
 
 func init() {
@@ -40,6 +67,17 @@ func init() {
 
 	// fake version number to have fixed hash values
 	containerflightVersion = "x.y.z"
+
+	getExecutable = func() string { return "/usr/local/bin/containerflight" }
+	getConfigDir = func() string { return "/config" }
+	getBinDir = func() string { return "/bin" }
+}
+
+// replace the file system of a test by an empty one
+func mockFilesystem() (restore func()) {
+	origFilesystem := filesystem
+	filesystem = afero.NewMemMapFs()
+	return func() { filesystem = origFilesystem }
 }
 
 func newMockHttpApiClient() *mockHttpApiClient {
@@ -85,13 +123,135 @@ func newMockHttpApiClient() *mockHttpApiClient {
 		},
 	}
 
-	return &mockHttpApiClient{imageRepo}
+	return &mockHttpApiClient{imageRepo: imageRepo, apiVersion: "1.40"}
 }
 
 func (c *mockHttpApiClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
 	return c.imageRepo, nil
 }
 
+func (c *mockHttpApiClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	containers := []types.Container{}
+	for _, container := range c.containers {
+		if options.Filters.MatchKVList("label", container.Labels) {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+func (c *mockHttpApiClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	for _, el := range c.imageRepo {
+		if el.ID == image {
+			return types.ImageInspect{ID: el.ID}, []byte{}, nil
+		}
+	}
+	return types.ImageInspect{}, []byte{}, errors.New("image not found")
+}
+
+func (c *mockHttpApiClient) ContainerKill(ctx context.Context, container, signal string) error {
+	c.calls = append(c.calls, "kill "+container+" "+signal)
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerResize(ctx context.Context, container string, options types.ResizeOptions) error {
+	c.calls = append(c.calls, fmt.Sprintf("resize %s %dx%d", container, options.Width, options.Height))
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	c.calls = append(c.calls, "create "+config.Image)
+	c.config = config
+	c.hostConfig = hostConfig
+	return container.ContainerCreateCreatedBody{ID: "abc"}, nil
+}
+
+func (c *mockHttpApiClient) ContainerAttach(ctx context.Context, container string, options types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	c.calls = append(c.calls, "attach "+container)
+	conn, _ := net.Pipe()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(bytes.NewReader(c.output))}, nil
+}
+
+func (c *mockHttpApiClient) ContainerStart(ctx context.Context, container string, options types.ContainerStartOptions) error {
+	c.calls = append(c.calls, "start "+container)
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	c.calls = append(c.calls, "wait "+containerID)
+	waitC := make(chan container.ContainerWaitOKBody, 1)
+	waitC <- container.ContainerWaitOKBody{StatusCode: c.statusCode}
+	return waitC, make(chan error)
+}
+
+func (c *mockHttpApiClient) ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error {
+	c.calls = append(c.calls, "remove "+container)
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	config, ok := c.containerConfigs[containerID]
+	if !ok {
+		config = &container.Config{}
+	}
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: containerID}, Config: config}, nil
+}
+
+func (c *mockHttpApiClient) ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	c.calls = append(c.calls, "logs "+container)
+	return ioutil.NopCloser(bytes.NewReader(c.output)), nil
+}
+
+func (c *mockHttpApiClient) ContainerStop(ctx context.Context, container string, timeout *time.Duration) error {
+	c.calls = append(c.calls, "stop "+container)
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error) {
+	c.calls = append(c.calls, "exec "+container)
+	if !config.Detach {
+		c.execConfig = config
+	}
+	c.execCmds = append(c.execCmds, config.Cmd)
+	return types.IDResponse{ID: fmt.Sprintf("exec%d", len(c.execCmds))}, nil
+}
+
+func (c *mockHttpApiClient) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	c.calls = append(c.calls, "start "+execID)
+	return nil
+}
+
+func (c *mockHttpApiClient) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	c.calls = append(c.calls, "attach "+execID)
+	conn, _ := net.Pipe()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(bytes.NewReader(c.output))}, nil
+}
+
+func (c *mockHttpApiClient) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	return types.ContainerExecInspect{ExecID: execID, ExitCode: int(c.statusCode)}, nil
+}
+
+func (c *mockHttpApiClient) ContainerExecResize(ctx context.Context, execID string, options types.ResizeOptions) error {
+	c.calls = append(c.calls, fmt.Sprintf("resize %s %dx%d", execID, options.Width, options.Height))
+	return nil
+}
+
+func (c *mockHttpApiClient) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	c.calls = append(c.calls, "build "+options.Tags[0])
+	c.buildContext, _ = ioutil.ReadAll(buildContext)
+	c.buildOptions = options
+	body := ioutil.NopCloser(strings.NewReader(`{"stream":"Successfully built 123\n"}`))
+	return types.ImageBuildResponse{Body: body}, nil
+}
+
+func (c *mockHttpApiClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Version: "19.03.6", APIVersion: "1.40", MinAPIVersion: "1.12", Os: "linux"}, nil
+}
+
+func (c *mockHttpApiClient) ClientVersion() string {
+	return c.apiVersion
+}
+
 func (c *mockHttpApiClient) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
 	respItems := []types.ImageDeleteResponseItem{}
 	for i, el := range c.imageRepo {
@@ -109,10 +269,7 @@ func newDockerClient(appInfo *appinfo.AppInfo) *DockerClient {
 	// Docker HTTP API client
 	client := newMockHttpApiClient()
 
-	// Docker cli client
-	var dockerCli dockerCliClient
-
-	return &DockerClient{appInfo: appInfo, client: client, dockerCli: dockerCli}
+	return &DockerClient{appInfo: appInfo, client: client}
 }
 func TestRemoveImages(t *testing.T) {
 	dockerClient := newDockerClient(&appinfo.AppInfo{})
@@ -168,6 +325,16 @@ func TestGetBuildCmdArgs(t *testing.T) {
 	assert.Equal(t, expArgs, args)
 }
 
+func TestGetRunCmdArgsWithoutInit(t *testing.T) {
+	appConfigStr := "runtime:\n    init: false"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	dockerClient := newDockerClient(appInfo)
+	args := dockerClient.getRunCmdArgs("123", []string{})
+
+	assert.NotContains(t, args, "--init")
+}
+
 func TestGetRunCmdArgs(t *testing.T) {
 	appConfigStr := ""
 	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
@@ -177,6 +344,7 @@ func TestGetRunCmdArgs(t *testing.T) {
 
 	expArgs := []string{
 		"--rm",
+		"--init",
 		"--label", "containerflight_appFile=/testAppFile",
 		"--label", "containerflight_image=containerflight_testappfile:unknown",
 		"--label", "containerflight_hash=562a792d764ddceb355634b2ccee3878edf696021767ff0e8144eab2e2bf035f",
@@ -255,3 +423,392 @@ func TestGetDockerContainerHashWithContext(t *testing.T) {
 
 	assert.Equal(t, "c90e2a76c380fae4b63ec88566a327637cfd6fc3f26f88cdc0137961b02d10d9", hashStr)
 }
+
+func TestIsContextUsedStages(t *testing.T) {
+	appConfigStr := "image:\n    stages:\n" +
+		"        - name: builder\n          base: docker://golang\n          dockerfile: RUN go build\n" +
+		"        - base: docker://ubuntu\n          dockerfile: COPY --from=builder /app /app"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	dockerClient := newDockerClient(appInfo)
+	assert.False(t, dockerClient.isContextUsed())
+}
+
+func TestGetBuildCmdArgsNetwork(t *testing.T) {
+	appConfigStr := "image:\n    network: none"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	dockerClient := newDockerClient(appInfo)
+	args := dockerClient.getBuildCmdArgs("dockerfile", "dockerBuildCtx", "label", "hashStr")
+
+	assert.Equal(t, []string{"--network", "none"}, args[len(args)-2:])
+
+	appInfo = appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "image:\n    network: bridge")
+	dockerClient = newDockerClient(appInfo)
+	args = dockerClient.getBuildCmdArgs("dockerfile", "dockerBuildCtx", "label", "hashStr")
+
+	assert.Equal(t, []string{"--network", "default"}, args[len(args)-2:])
+}
+
+func TestCheckDetachedRun(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	dockerClient := newDockerClient(appInfo)
+
+	assert.True(t, dockerClient.checkDetachedRun())
+	appInfo.AddDockerRunArgs("--detach")
+	assert.True(t, dockerClient.checkDetachedRun())
+
+	// temporary resources would be removed while the container is running, also if the run is passed
+	// to the docker cli
+	for _, appConfigStr := range []string{"gui: x11", "dbus:\n    bus: session\n    talk: [org.freedesktop.Notifications]"} {
+		appInfo = appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+		dockerClient = newDockerClient(appInfo)
+		assert.True(t, dockerClient.checkDetachedRun())
+
+		appInfo.AddDockerRunArgs("--gpus", "all", "-dit")
+		testForLogFatal(t, func() { dockerClient.checkDetachedRun() })
+	}
+}
+
+func TestGetDockerContainerHashRunAndBuild(t *testing.T) {
+	dockerClientBuild := newDockerClient(appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", ""))
+	hashStr := dockerClientBuild.getDockerContainerHash()
+
+	// run options of the command line only apply to the container
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	appInfo.OverrideResources("", "")
+	assert.Equal(t, hashStr, newDockerClient(appInfo).getDockerContainerHash())
+
+	appInfo.OverrideResources("2", "1g")
+	appInfo.AddDockerRunArgs(RunOptions{Env: []string{"A=1"}, Volumes: []string{"/data:/data"}}.getDockerRunArgs()...)
+	assert.Equal(t, hashStr, newDockerClient(appInfo).getDockerContainerHash())
+}
+
+func TestGetDockerContainerHashWithSecret(t *testing.T) {
+	defer mockFilesystem()()
+
+	appConfigStr := "secrets:\n    - id: mysecret\n      env: TOKEN"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	// the secret is not part of the app config but changes the image
+	appInfoNoSecret := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	dockerClientNoSecret := newDockerClient(appInfoNoSecret)
+
+	assert.Equal(t, appInfoNoSecret.GetResolvedAppConfig(), appInfo.GetResolvedAppConfig())
+	assert.NotEqual(t, dockerClientNoSecret.getDockerContainerHash(), dockerClient.getDockerContainerHash())
+}
+
+func TestGetSecretsDigestKey(t *testing.T) {
+	defer mockFilesystem()()
+
+	key := getSecretsDigestKey()
+	assert.Len(t, key, 32)
+	fi, err := filesystem.Stat("/config/secrets.key")
+	util.CheckErr(err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	// the key is kept
+	assert.Equal(t, key, getSecretsDigestKey())
+}
+
+func TestGetDockerContainerHashWithoutSecret(t *testing.T) {
+	defer mockFilesystem()()
+
+	appConfigStr := "secrets:\n    - id: mysecret\n      file: /nonexistent/token"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	// an image which has been built with the secret
+	hashStr := dockerClient.computeDockerContainerHash("secretsDigest")
+	httpApiClient.imageRepo = append(httpApiClient.imageRepo, types.ImageSummary{ID: "sha256:abc", Labels: map[string]string{
+		"containerflight_appFile":       "/testAppFile",
+		"containerflight_hash":          hashStr,
+		"containerflight_secretsDigest": "secretsDigest",
+	}})
+
+	// the image is found although the secret is not available
+	assert.Equal(t, hashStr, dockerClient.getDockerContainerHash())
+	imageID, err := dockerClient.getDockerContainerImageID(dockerClient.getDockerContainerHash())
+	util.CheckErr(err)
+	assert.Equal(t, "sha256:abc", imageID)
+}
+
+func TestGetBuildSecretArgs(t *testing.T) {
+	appConfigStr := "secrets:\n    - id: fromfile\n      file: /secret\n    - id: fromenv\n      env: TOKEN"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+
+	dockerClient := newDockerClient(appInfo)
+	secretArgs, removeSecretFiles := dockerClient.getBuildSecretArgs()
+
+	assert.Equal(t, 4, len(secretArgs))
+	assert.Equal(t, []string{"--secret", "id=fromfile,src=/secret", "--secret"}, secretArgs[:3])
+	assert.Regexp(t, "^id=fromenv,src=.*containerflight-secret", secretArgs[3])
+
+	// value of the environment variable (fake environment returns the variable name)
+	secretFile := strings.TrimPrefix(secretArgs[3], "id=fromenv,src=")
+	rawData, err := afero.ReadFile(filesystem, secretFile)
+	util.CheckErr(err)
+	assert.Equal(t, "TOKEN", string(rawData))
+
+	removeSecretFiles()
+	_, err = filesystem.Stat(secretFile)
+	assert.Error(t, err)
+}
+
+func TestBuildArgs(t *testing.T) {
+	appConfigStr := "image:\n    buildArgs:\n        VERSION: \"1.2\"\n        TOKEN: ${ENV(TOKEN)}"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	assert.Equal(t, []string{"--build-arg", "TOKEN=TOKEN", "--build-arg", "VERSION=1.2"}, dockerClient.getBuildArgCmdArgs())
+	assert.Equal(t, "1.2", *dockerClient.getBuildOptions("label", "hashStr").BuildArgs["VERSION"])
+
+	// only a build arg of the command line changes the hash
+	hashStr := dockerClient.getDockerContainerHash()
+	appInfo.OverrideBuildArgs([]string{"HTTP_PROXY=http://proxy:3128"})
+	assert.Equal(t, hashStr, dockerClient.getDockerContainerHash())
+	appInfo.OverrideBuildArgs([]string{"VERSION=1.3"})
+	assert.NotEqual(t, hashStr, dockerClient.getDockerContainerHash())
+}
+
+func TestRunOptionsDockerRunArgs(t *testing.T) {
+	options := RunOptions{
+		Env:        []string{"A=1"},
+		Volumes:    []string{"/data:/data"},
+		RunArgs:    []string{"--privileged"},
+		Entrypoint: "/bin/sh",
+		Workdir:    "/data",
+	}
+
+	expArgs := []string{"-e", "A=1", "-v", "/data:/data", "--privileged", "--entrypoint", "/bin/sh", "-w", "/data"}
+	assert.Equal(t, expArgs, options.getDockerRunArgs())
+}
+
+func TestShellOptionsDockerRunArgs(t *testing.T) {
+	runArgs, args := ShellOptions{Root: true}.getDockerRunArgs()
+	assert.Equal(t, []string{"--entrypoint", "/bin/sh", "-u", "root"}, runArgs)
+	assert.Equal(t, []string{"-c", defaultShellCmd}, args)
+
+	runArgs, args = ShellOptions{Shell: "/bin/zsh"}.getDockerRunArgs()
+	assert.Equal(t, []string{"--entrypoint", "/bin/zsh"}, runArgs)
+	assert.Equal(t, []string{}, args)
+}
+
+func newAppContainer(id string, name string, appConfigFile string) types.Container {
+	return types.Container{
+		ID:     id,
+		Names:  []string{"/" + name},
+		Labels: map[string]string{"containerflight_appFile": appConfigFile},
+	}
+}
+
+func TestFindAppContainer(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+	httpApiClient.containers = []types.Container{
+		newAppContainer("aaa111", "first", "/testAppFile"),
+		newAppContainer("bbb222", "other", "/otherAppFile"),
+	}
+
+	assert.Equal(t, "first", getContainerName(dockerClient.findAppContainer("")))
+	assert.Equal(t, 2, len(dockerClient.getAppContainers("")))
+
+	// select by ID prefix or name if several containers are running
+	httpApiClient.containers = append(httpApiClient.containers, newAppContainer("ccc333", "second", "/testAppFile"))
+	assert.Equal(t, "second", getContainerName(dockerClient.findAppContainer("ccc")))
+	assert.Equal(t, "first", getContainerName(dockerClient.findAppContainer("first")))
+}
+
+func TestFindAppContainerAmbiguous(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+	httpApiClient.containers = []types.Container{
+		newAppContainer("aaa111", "first", "/testAppFile"),
+		newAppContainer("ccc333", "second", "/testAppFile"),
+	}
+
+	testForLogFatal(t, func() { dockerClient.findAppContainer("") })
+	testForLogFatal(t, func() { dockerClient.findAppContainer("unknown") })
+}
+
+func TestGetExecConfig(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	dockerClient := newDockerClient(appInfo)
+
+	appContainer := newAppContainer("aaa111", "first", "/testAppFile")
+	assert.Equal(t, types.ExecConfig{AttachStdout: true, AttachStderr: true, Cmd: []string{"make", "all"}},
+		dockerClient.getExecConfig(appContainer, []string{"make", "all"}))
+
+	// working directory is mounted
+	appContainer.Mounts = []types.MountPoint{{Destination: "/myworkingdir"}}
+	config := dockerClient.getExecConfig(appContainer, []string{})
+	assert.Equal(t, "/myworkingdir", config.WorkingDir)
+	assert.Equal(t, []string{"/bin/sh", "-c", defaultShellCmd}, config.Cmd)
+
+	// the user of the container, runargs of the exec invocation do not matter
+	dockerClient.client.(*mockHttpApiClient).containerConfigs = map[string]*container.Config{"aaa111": {User: "1000:1000"}}
+	appInfo.AddDockerRunArgs("-u", "${USERNAME}")
+	assert.Equal(t, "1000:1000", dockerClient.getExecConfig(appContainer, []string{"make"}).User)
+
+	// console settings of the app
+	appInfo = appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: true")
+	config = newDockerClient(appInfo).getExecConfig(appContainer, []string{"make"})
+	assert.True(t, config.Tty)
+	assert.True(t, config.AttachStdin)
+}
+
+func TestExecuteExec(t *testing.T) {
+	stdoutBuffer, stderrBuffer, restore := mockStreams()
+	defer restore()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	output := &bytes.Buffer{}
+	stdcopy.NewStdWriter(output, stdcopy.Stdout).Write([]byte("hello\n"))
+	stdcopy.NewStdWriter(output, stdcopy.Stderr).Write([]byte("warning\n"))
+	httpApiClient.output = output.Bytes()
+	httpApiClient.statusCode = 2
+
+	container := newAppContainer("aaa111", "first", "/testAppFile")
+	statusCode, err := dockerClient.executeExec(container.ID, dockerClient.getExecConfig(container, []string{"make"}))
+	util.CheckErr(err)
+
+	assert.Equal(t, 2, statusCode)
+	assert.Equal(t, []string{"exec aaa111", "attach exec1", "exec aaa111", "start exec2"}, httpApiClient.calls)
+
+	// the command stores its process ID for the signal proxy, the file is removed afterwards
+	pidFile := httpApiClient.execConfig.Cmd[3]
+	assert.True(t, strings.HasPrefix(pidFile, execPIDFilePrefix))
+	assert.Equal(t, []string{"/bin/sh", "-c", execScript, pidFile, "make"}, httpApiClient.execConfig.Cmd)
+	assert.Equal(t, []string{"rm", "-f", pidFile}, httpApiClient.execCmds[1])
+	assert.Equal(t, "hello\n", stdoutBuffer.String())
+	assert.Equal(t, "warning\n", stderrBuffer.String())
+}
+
+func TestShowLogs(t *testing.T) {
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	dockerClient := newDockerClient(appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", ""))
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	// the output of a container with TTY is not multiplexed
+	httpApiClient.containerConfigs = map[string]*container.Config{"aaa111": {Tty: true}}
+	httpApiClient.output = []byte("raw output")
+
+	util.CheckErr(dockerClient.showLogs("aaa111", true))
+	assert.Equal(t, []string{"logs aaa111"}, httpApiClient.calls)
+	assert.Equal(t, "raw output", stdoutBuffer.String())
+}
+
+func TestStopContainers(t *testing.T) {
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	dockerClient := newDockerClient(appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", ""))
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	util.CheckErr(dockerClient.stopContainers([]string{"aaa111", "bbb222"}))
+	assert.Equal(t, []string{"stop aaa111", "stop bbb222"}, httpApiClient.calls)
+	assert.Equal(t, "aaa111\nbbb222\n", stdoutBuffer.String())
+}
+
+func TestFindPersistentContainer(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "runtime:\n    mode: persistent")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	persistentContainer := newAppContainer("aaa111", dockerClient.getPersistentContainerName(), "/testAppFile")
+	persistentContainer.Labels["containerflight_hash"] = dockerClient.getDockerContainerHash()
+	persistentContainer.Labels["containerflight_persistent"] = "true"
+	persistentContainer.Labels["containerflight_workingDir"] = "/myworkingdir"
+	httpApiClient.containers = []types.Container{newAppContainer("bbb222", "other", "/testAppFile")}
+
+	_, ok := dockerClient.findPersistentContainer()
+	assert.False(t, ok)
+
+	httpApiClient.containers = append(httpApiClient.containers, persistentContainer)
+	container, ok := dockerClient.findPersistentContainer()
+	assert.True(t, ok)
+	assert.Equal(t, "aaa111", container.ID)
+
+	// another working directory needs another container
+	origGetWorkingDir := util.GetWorkingDir
+	defer func() { util.GetWorkingDir = origGetWorkingDir }()
+	util.GetWorkingDir = func() string { return "/otherworkingdir" }
+	_, ok = dockerClient.findPersistentContainer()
+	assert.False(t, ok)
+}
+
+func TestGetPersistentExecConfig(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false\nruntime:\n    mode: persistent")
+	dockerClient := newDockerClient(appInfo)
+
+	image := types.ImageInspect{Config: &container.Config{Entrypoint: []string{"gcc"}, Cmd: []string{"--version"}}}
+	expCmd := []string{"gcc"}
+
+	config := dockerClient.getPersistentExecConfig(image, []string{"-c", "main.c"}, RunOptions{})
+	assert.Equal(t, append(expCmd, "-c", "main.c"), config.Cmd)
+	assert.Equal(t, "/myworkingdir", config.WorkingDir)
+	assert.Equal(t, append(expCmd, "--version"), dockerClient.getPersistentExecConfig(image, []string{}, RunOptions{}).Cmd)
+
+	// options of the command line which apply to each invocation
+	options := RunOptions{Env: []string{"A=1"}, Workdir: "/src", Entrypoint: "clang"}
+	config = dockerClient.getPersistentExecConfig(image, []string{}, options)
+	assert.Equal(t, []string{"clang"}, config.Cmd)
+	assert.Equal(t, []string{"A=1"}, config.Env)
+	assert.Equal(t, "/src", config.WorkingDir)
+}
+
+func TestGetPersistentOptionsHash(t *testing.T) {
+	optionsHash := RunOptions{}.getPersistentOptionsHash()
+
+	// options which only apply to an invocation do not matter
+	assert.Equal(t, optionsHash, RunOptions{Env: []string{"A=1"}, Workdir: "/src", Entrypoint: "clang"}.getPersistentOptionsHash())
+
+	assert.NotEqual(t, optionsHash, RunOptions{Volumes: []string{"/data:/data"}}.getPersistentOptionsHash())
+	assert.NotEqual(t, optionsHash, RunOptions{RunArgs: []string{"--privileged"}}.getPersistentOptionsHash())
+	assert.NotEqual(t, optionsHash, RunOptions{Memory: "1g"}.getPersistentOptionsHash())
+}
+
+func TestRunPersistentOtherOptions(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false\nruntime:\n    mode: persistent")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+	httpApiClient.imageRepo[1].Labels["containerflight_hash"] = dockerClient.getDockerContainerHash()
+
+	persistentContainer := newAppContainer("aaa111", dockerClient.getPersistentContainerName(), "/testAppFile")
+	persistentContainer.Labels["containerflight_hash"] = dockerClient.getDockerContainerHash()
+	persistentContainer.Labels["containerflight_persistent"] = "true"
+	persistentContainer.Labels["containerflight_workingDir"] = "/myworkingdir"
+	persistentContainer.Labels["containerflight_runOptions"] = RunOptions{}.getPersistentOptionsHash()
+	httpApiClient.containers = []types.Container{persistentContainer}
+
+	// a volume cannot be added to the running container
+	testForLogFatal(t, func() {
+		dockerClient.runPersistent([]string{}, RunOptions{Volumes: []string{"/data:/data"}})
+	})
+}
+
+func testForLogFatal(t *testing.T, testFunc func()) {
+
+	origLogFatalf := logFatalf
+	defer func() { logFatalf = origLogFatalf }()
+
+	numErrors := 0
+	logFatalf = func(format string, args ...interface{}) {
+		numErrors++
+	}
+
+	testFunc()
+
+	if numErrors != 1 {
+		t.Errorf("excepted one error, actual %v", numErrors)
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/builder/dockerignore"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/pkg/term"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"golang.org/x/net/context"
+)
+
+// "mock connectors" for unit-tesing
+var stdin io.Reader = os.Stdin
+var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
+
+// name of the generated Dockerfile in the build context
+const buildContextDockerfile = "Dockerfile.containerflight"
+
+// executeRun runs a container through the Engine API and returns its exit code, an error is returned
+// for "docker run" options which cannot be translated
+func (dc *DockerClient) executeRun(runCmdArgs []string) (int, error) {
+	spec, err := parseRunCmdArgs(runCmdArgs)
+	if err != nil {
+		return 0, fmt.Errorf("unsupported docker run arguments \"%s\": %v", strings.Join(runCmdArgs, " "), err)
+	}
+
+	log.Debug("run \"" + strings.Join(runCmdArgs, " ") + "\" through the Engine API")
+	return dc.runContainer(spec)
+}
+
+// runContainer creates and starts a container, the terminal is attached to it until it exits unless
+// it is detached
+func (dc *DockerClient) runContainer(spec *runSpec) (int, error) {
+	ctx := context.Background()
+
+	created, err := dc.client.ContainerCreate(ctx, spec.config, spec.hostConfig, nil, spec.name)
+	if err != nil {
+		return 0, err
+	}
+	for _, warning := range created.Warnings {
+		log.Warn(warning)
+	}
+
+	if spec.detach {
+		err = dc.client.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintln(stdout, created.ID)
+		return 0, nil
+	}
+
+	// the container is removed after its exit code has been received
+	if spec.remove {
+		defer dc.removeContainer(created.ID)
+	}
+
+	attachOptions := types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  spec.config.AttachStdin,
+		Stdout: spec.config.AttachStdout,
+		Stderr: spec.config.AttachStderr,
+	}
+	attachment, err := dc.client.ContainerAttach(ctx, created.ID, attachOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer attachment.Close()
+
+	if spec.config.Tty && spec.config.AttachStdin {
+		restoreTerminal := setRawTerminal()
+		defer restoreTerminal()
+	}
+
+	outputDone := streamAttachment(attachment, spec.config.Tty, spec.config.AttachStdin)
+
+	err = dc.client.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	if err != nil {
+		return 0, err
+	}
+	if spec.config.Tty {
+		if err := resizeContainer(dc.client, created.ID); err != nil {
+			log.Debug("cannot resize TTY: ", err)
+		}
+	}
+
+	waitC, waitErrC := dc.client.ContainerWait(ctx, created.ID, container.WaitConditionNotRunning)
+	select {
+	case result := <-waitC:
+		if err := <-outputDone; err != nil {
+			log.Debug("cannot read container output: ", err)
+		}
+		if result.Error != nil {
+			return 0, errors.New(result.Error.Message)
+		}
+		return int(result.StatusCode), nil
+	case err := <-waitErrC:
+		return 0, err
+	}
+}
+
+// an exec stores its process ID in a file with this prefix so that signals can be forwarded to it
+const execPIDFilePrefix = "/tmp/.containerflight.exec."
+
+// wrapper of an exec, the command keeps the process ID of the shell (a command also runs if the
+// file cannot be written)
+const execScript = `{ echo $$ > "$0"; } 2>/dev/null
+exec "$@"`
+
+// send a signal to the process of an exec
+const killScript = `kill -"$1" "$(cat "$0")"`
+
+// executeExec runs a command in a running container through the Engine API and returns its exit code,
+// signals are forwarded to the command
+func (dc *DockerClient) executeExec(containerID string, config types.ExecConfig) (int, error) {
+	ctx := context.Background()
+
+	log.Debug("exec \"" + strings.Join(config.Cmd, " ") + "\" in " + shortContainerID(containerID) + " through the Engine API")
+	pidFile := execPIDFilePrefix + newRunID()
+	config.Cmd = append([]string{"/bin/sh", "-c", execScript, pidFile}, config.Cmd...)
+	created, err := dc.client.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := execDetached(dc.client, containerID, []string{"rm", "-f", pidFile}); err != nil {
+			log.Debug("cannot remove process ID file: ", err)
+		}
+	}()
+
+	proxy := newSignalProxy(execTarget{dc.client, containerID, created.ID, pidFile}, config.Tty)
+	proxy.start()
+	defer proxy.stop()
+
+	// attaching starts the command
+	attachment, err := dc.client.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: config.Tty})
+	if err != nil {
+		return 0, err
+	}
+	defer attachment.Close()
+
+	if config.Tty && config.AttachStdin {
+		restoreTerminal := setRawTerminal()
+		defer restoreTerminal()
+	}
+	outputDone := streamAttachment(attachment, config.Tty, config.AttachStdin)
+	if config.Tty {
+		if err := resizeExec(dc.client, created.ID); err != nil {
+			log.Debug("cannot resize TTY: ", err)
+		}
+	}
+	if err := <-outputDone; err != nil {
+		log.Debug("cannot read exec output: ", err)
+	}
+
+	return dc.getExecExitCode(created.ID)
+}
+
+// run a command in a running container without waiting for it
+func execDetached(client dockerHttpApiClient, containerID string, cmd []string) error {
+	ctx := context.Background()
+	created, err := client.ContainerExecCreate(ctx, containerID, types.ExecConfig{Detach: true, Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	return client.ContainerExecStart(ctx, created.ID, types.ExecStartCheck{Detach: true})
+}
+
+// get the exit code of an exec, the process can still be running for a moment after its output has ended
+func (dc *DockerClient) getExecExitCode(execID string) (int, error) {
+	for {
+		inspect, err := dc.client.ContainerExecInspect(context.Background(), execID)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// attachContainer connects the terminal to a running container until its output ends, signals are
+// forwarded to the container
+func (dc *DockerClient) attachContainer(containerID string) (int, error) {
+	ctx := context.Background()
+
+	inspect, err := dc.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	config := inspect.Config
+
+	attachOptions := types.ContainerAttachOptions{Stream: true, Stdin: config.OpenStdin, Stdout: true, Stderr: true}
+	attachment, err := dc.client.ContainerAttach(ctx, containerID, attachOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer attachment.Close()
+
+	if config.Tty && config.OpenStdin {
+		restoreTerminal := setRawTerminal()
+		defer restoreTerminal()
+	}
+
+	proxy := newSignalProxy(containerTarget{dc.client, containerID}, config.Tty)
+	proxy.start()
+	defer proxy.stop()
+
+	outputDone := streamAttachment(attachment, config.Tty, config.OpenStdin)
+	if config.Tty {
+		if err := resizeContainer(dc.client, containerID); err != nil {
+			log.Debug("cannot resize TTY: ", err)
+		}
+	}
+	if err := <-outputDone; err != nil {
+		log.Debug("cannot read container output: ", err)
+	}
+
+	// the output also ends if the streams are detached from a running container
+	inspect, err = dc.client.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.State == nil || inspect.State.Running {
+		return 0, nil
+	}
+	return inspect.State.ExitCode, nil
+}
+
+// showLogs copies the output of a container to stdout and stderr
+func (dc *DockerClient) showLogs(containerID string, follow bool) error {
+	ctx := context.Background()
+
+	inspect, err := dc.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	logsOptions := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: follow}
+	logs, err := dc.client.ContainerLogs(ctx, containerID, logsOptions)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	return copyOutput(logs, inspect.Config.Tty)
+}
+
+// stopContainers stops running containers and prints their IDs like "docker stop", the stop timeout
+// of each container applies
+func (dc *DockerClient) stopContainers(containerIDs []string) error {
+	for _, containerID := range containerIDs {
+		if err := dc.client.ContainerStop(context.Background(), containerID, nil); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, containerID)
+	}
+	return nil
+}
+
+// copy the output of an attached container or exec to stdout and stderr and stdin to it, the returned
+// channel receives the result when the output has ended
+func streamAttachment(attachment types.HijackedResponse, tty bool, attachStdin bool) <-chan error {
+	outputDone := make(chan error, 1)
+	go func() {
+		outputDone <- copyOutput(attachment.Reader, tty)
+	}()
+	if attachStdin {
+		go func() {
+			io.Copy(attachment.Conn, stdin)
+			attachment.CloseWrite()
+		}()
+	}
+	return outputDone
+}
+
+// copy the output of a container, stdout and stderr are multiplexed without TTY
+func copyOutput(reader io.Reader, tty bool) error {
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	return err
+}
+
+// remove a stopped container including its anonymous volumes
+func (dc *DockerClient) removeContainer(containerID string) {
+	options := types.ContainerRemoveOptions{RemoveVolumes: true, Force: true}
+	if err := dc.client.ContainerRemove(context.Background(), containerID, options); err != nil {
+		log.Warn("cannot remove container: ", err)
+	}
+}
+
+// switch the terminal into raw mode so that all input reaches the container TTY
+func setRawTerminal() (restoreTerminal func()) {
+	fd, isTerminal := term.GetFdInfo(stdin)
+	if !isTerminal {
+		return func() {}
+	}
+	state, err := term.SetRawTerminal(fd)
+	if err != nil {
+		log.Debug("cannot set raw terminal: ", err)
+		return func() {}
+	}
+	return func() { term.RestoreTerminal(fd, state) }
+}
+
+// buildImage builds an app image through the Engine API
+func (dc *DockerClient) buildImage(dockerBuildCtx string, label string, hashStr string) error {
+	buildContext := dc.getBuildContext(dockerBuildCtx)
+	defer buildContext.Close()
+
+	log.Debug("build \"" + label + "\" through the Engine API")
+
+	response, err := dc.client.ImageBuild(context.Background(), buildContext, dc.getBuildOptions(label, hashStr))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// errors of the build are returned also if the output is discarded
+	output := stdout
+	fd, isTerminal := term.GetFdInfo(stdout)
+	switch buildProgress {
+	case progressPlain:
+		isTerminal = false
+	case progressTTY:
+		isTerminal = true
+	case progressQuiet:
+		output = ioutil.Discard
+	}
+	return jsonmessage.DisplayJSONMessagesStream(response.Body, output, fd, isTerminal, nil)
+}
+
+// get the Engine API options of an image build
+func (dc *DockerClient) getBuildOptions(label string, hashStr string) types.ImageBuildOptions {
+	labels := map[string]string{}
+	for _, imageLabel := range dc.getImageLabels(hashStr) {
+		keyValue := strings.SplitN(imageLabel, "=", 2)
+		labels[keyValue[0]] = keyValue[1]
+	}
+
+	buildArgs := map[string]*string{}
+	for name, value := range dc.appInfo.GetBuildArgs() {
+		value := value
+		buildArgs[name] = &value
+	}
+
+	return types.ImageBuildOptions{
+		Tags:           []string{label},
+		Dockerfile:     buildContextDockerfile,
+		Labels:         labels,
+		BuildArgs:      buildArgs,
+		Remove:         true,
+		ForceRemove:    true,
+		SuppressOutput: buildProgress == progressQuiet,
+		NetworkMode:    dc.appInfo.GetBuildNetwork(),
+		Version:        types.BuilderV1,
+	}
+}
+
+// getBuildContext streams a tar archive with the generated Dockerfile, the files of the app file directory
+// are only added if they are used by the Dockerfile. Errors of the archive are returned by the reader.
+func (dc *DockerClient) getBuildContext(dockerBuildCtx string) io.ReadCloser {
+	isContextUsed := dc.isContextUsed()
+	dockerfile := []byte(dc.appInfo.GetDockerfile())
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(writeBuildContext(pipeWriter, dockerBuildCtx, isContextUsed, dockerfile))
+	}()
+	return pipeReader
+}
+
+// write the build context as tar archive
+func writeBuildContext(writer io.Writer, dockerBuildCtx string, isContextUsed bool, dockerfile []byte) error {
+	tarWriter := tar.NewWriter(writer)
+
+	if isContextUsed {
+		if err := addBuildContextFiles(tarWriter, dockerBuildCtx); err != nil {
+			return err
+		}
+	}
+
+	header := &tar.Header{
+		Name:    buildContextDockerfile,
+		Mode:    0644,
+		Size:    int64(len(dockerfile)),
+		ModTime: time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(dockerfile); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// add the files of the build context directory to a tar archive, files matching the
+// .dockerignore patterns are skipped
+func addBuildContextFiles(tarWriter *tar.Writer, dockerBuildCtx string) error {
+	patterns, err := readDockerignore(dockerBuildCtx)
+	if err != nil {
+		return err
+	}
+	excludes, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return err
+	}
+
+	return afero.Walk(filesystem, dockerBuildCtx, func(fileName string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relFileName, err := filepath.Rel(dockerBuildCtx, fileName)
+		if err != nil || relFileName == "." {
+			return err
+		}
+
+		excluded, err := excludes.Matches(relFileName)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			// a directory must be visited if one of its files could be re-included
+			if fi.IsDir() && !excludes.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			linkReader, ok := filesystem.(afero.LinkReader)
+			if !ok {
+				return nil
+			}
+			if link, err = linkReader.ReadlinkIfPossible(fileName); err != nil {
+				return err
+			}
+		} else if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relFileName)
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		fh, err := filesystem.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		_, err = io.Copy(tarWriter, fh)
+		return err
+	})
+}
+
+// read the exclude patterns of the build context
+func readDockerignore(dockerBuildCtx string) ([]string, error) {
+	fh, err := filesystem.Open(filepath.Join(dockerBuildCtx, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return dockerignore.ReadAll(fh)
+}
@@ -0,0 +1,345 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+)
+
+func TestParseRunCmdArgs(t *testing.T) {
+	spec, err := parseRunCmdArgs([]string{
+		"--rm", "--init", "-ti", "--label", "containerflight_run=run1", "--sig-proxy=false",
+		"-v", "/home:/home", "-v", "/cache", "-e", "A=1", "-h", "flybydocker", "-w", "/myworkingdir",
+		"--cap-drop", "ALL", "--read-only", "--tmpfs", "/tmp:size=64m", "-p", "8080:80",
+		"--cpus", "1.5", "--memory", "512m", "--pids-limit", "100", "--ulimit", "nofile=1024:2048",
+		"--device", "/dev/snd", "--stop-timeout", "30",
+		"sha256:456", "make", "-j", "4",
+	})
+	util.CheckErr(err)
+
+	assert.False(t, spec.detach)
+	assert.True(t, spec.remove)
+
+	config := spec.config
+	assert.Equal(t, "sha256:456", config.Image)
+	assert.Equal(t, []string{"make", "-j", "4"}, []string(config.Cmd))
+	assert.True(t, config.Tty)
+	assert.True(t, config.AttachStdin)
+	assert.Equal(t, "run1", config.Labels["containerflight_run"])
+	assert.Equal(t, []string{"A=1"}, config.Env)
+	assert.Equal(t, "flybydocker", config.Hostname)
+	assert.Equal(t, "/myworkingdir", config.WorkingDir)
+	assert.Contains(t, config.Volumes, "/cache")
+	assert.Equal(t, 30, *config.StopTimeout)
+
+	hostConfig := spec.hostConfig
+	assert.False(t, hostConfig.AutoRemove)
+	assert.True(t, *hostConfig.Init)
+	assert.Equal(t, []string{"/home:/home"}, hostConfig.Binds)
+	assert.Equal(t, []string{"ALL"}, []string(hostConfig.CapDrop))
+	assert.True(t, hostConfig.ReadonlyRootfs)
+	assert.Equal(t, map[string]string{"/tmp": "size=64m"}, hostConfig.Tmpfs)
+	assert.Equal(t, "8080", hostConfig.PortBindings["80/tcp"][0].HostPort)
+	assert.Equal(t, int64(1500000000), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(512*1024*1024), hostConfig.Memory)
+	assert.Equal(t, int64(100), *hostConfig.PidsLimit)
+	assert.Equal(t, int64(2048), hostConfig.Ulimits[0].Hard)
+	assert.Equal(t, container.DeviceMapping{PathOnHost: "/dev/snd", PathInContainer: "/dev/snd", CgroupPermissions: "rwm"},
+		hostConfig.Devices[0])
+}
+
+func TestParseRunCmdArgsDetach(t *testing.T) {
+	spec, err := parseRunCmdArgs([]string{"--rm", "--detach", "-i", "--name", "myapp", "sha256:456"})
+	util.CheckErr(err)
+
+	assert.True(t, spec.detach)
+	assert.Equal(t, "myapp", spec.name)
+	assert.True(t, spec.hostConfig.AutoRemove)
+	assert.True(t, spec.config.OpenStdin)
+	assert.False(t, spec.config.AttachStdin)
+	assert.False(t, spec.config.AttachStdout)
+}
+
+func TestParseRunCmdArgsUnsupported(t *testing.T) {
+	_, err := parseRunCmdArgs([]string{"--rm", "--runtime", "runsc", "sha256:456"})
+	assert.Error(t, err)
+
+	_, err = parseRunCmdArgs([]string{"--rm"})
+	assert.Error(t, err)
+}
+
+func TestParseRunCmdArgsMountGpusEnvFile(t *testing.T) {
+	defer mockFilesystem()()
+	afero.WriteFile(filesystem, "/app.env", []byte("# comment\nA=1\n\n  B=2\r\nCONTAINERFLIGHT_UNSET\n"), 0644)
+
+	spec, err := parseRunCmdArgs([]string{
+		"--rm", "--env-file", "/app.env", "-e", "C=3",
+		"--mount", "type=bind,source=/data,target=/data,readonly", "--mount", "target=/cache,volume-nocopy",
+		"--gpus", "all", "sha256:456",
+	})
+	util.CheckErr(err)
+
+	assert.Equal(t, []string{"A=1", "B=2", "C=3"}, spec.config.Env)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeBind, Source: "/data", Target: "/data", ReadOnly: true},
+		{Type: mount.TypeVolume, Target: "/cache", VolumeOptions: &mount.VolumeOptions{NoCopy: true}},
+	}, spec.hostConfig.Mounts)
+	assert.Equal(t, []container.DeviceRequest{{Count: -1, Capabilities: [][]string{{"gpu"}}}}, spec.hostConfig.DeviceRequests)
+
+	_, err = parseRunCmdArgs([]string{"--rm", "--env-file", "/missing.env", "sha256:456"})
+	assert.Error(t, err)
+}
+
+func TestGetRunMount(t *testing.T) {
+	runMount, err := getRunMount("type=tmpfs,dst=/tmp,tmpfs-size=64m,tmpfs-mode=1770")
+	util.CheckErr(err)
+	assert.Equal(t, mount.Mount{Type: mount.TypeTmpfs, Target: "/tmp", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 * 1024 * 1024, Mode: 01770}},
+		runMount)
+
+	runMount, err = getRunMount("type=bind,src=/src,target=/src,ro=false,bind-propagation=rslave")
+	util.CheckErr(err)
+	assert.False(t, runMount.ReadOnly)
+	assert.Equal(t, mount.PropagationRSlave, runMount.BindOptions.Propagation)
+
+	_, err = getRunMount("source=/data")
+	assert.Error(t, err)
+	_, err = getRunMount("target=/data,readonly=maybe")
+	assert.Error(t, err)
+	_, err = getRunMount("target=/data,volume-opt=type=nfs")
+	assert.Error(t, err)
+}
+
+func TestGetRunGpus(t *testing.T) {
+	deviceRequest, err := getRunGpus("2")
+	util.CheckErr(err)
+	assert.Equal(t, container.DeviceRequest{Count: 2, Capabilities: [][]string{{"gpu"}}}, deviceRequest)
+
+	deviceRequest, err = getRunGpus(`"device=0,1",driver=nvidia,capabilities=compute`)
+	util.CheckErr(err)
+	assert.Equal(t, container.DeviceRequest{Driver: "nvidia", DeviceIDs: []string{"0", "1"}, Capabilities: [][]string{{"compute"}}},
+		deviceRequest)
+
+	_, err = getRunGpus("many")
+	assert.Error(t, err)
+	_, err = getRunGpus(`count=1,"device=0"`)
+	assert.Error(t, err)
+}
+
+func TestParseRunCmdArgsAttach(t *testing.T) {
+	spec, err := parseRunCmdArgs([]string{"--rm", "-a", "stdin", "-a", "stdout", "sha256:456"})
+	util.CheckErr(err)
+
+	assert.True(t, spec.config.AttachStdin)
+	assert.True(t, spec.config.AttachStdout)
+	assert.False(t, spec.config.AttachStderr)
+	assert.False(t, spec.config.StdinOnce)
+
+	spec, err = parseRunCmdArgs([]string{"--rm", "sha256:456"})
+	util.CheckErr(err)
+	assert.False(t, spec.config.AttachStdin)
+	assert.True(t, spec.config.AttachStdout)
+	assert.True(t, spec.config.AttachStderr)
+
+	_, err = parseRunCmdArgs([]string{"--rm", "-a", "stdio", "sha256:456"})
+	assert.Error(t, err)
+}
+
+func TestGetRunDevice(t *testing.T) {
+	assert.Equal(t, container.DeviceMapping{PathOnHost: "/dev/a", PathInContainer: "/dev/b", CgroupPermissions: "rwm"},
+		getRunDevice("/dev/a:/dev/b"))
+	assert.Equal(t, container.DeviceMapping{PathOnHost: "/dev/a", PathInContainer: "/dev/a", CgroupPermissions: "r"},
+		getRunDevice("/dev/a:r"))
+	assert.Equal(t, container.DeviceMapping{PathOnHost: "/dev/a", PathInContainer: "/dev/b", CgroupPermissions: "rw"},
+		getRunDevice("/dev/a:/dev/b:rw"))
+}
+
+// replace the standard streams of a run
+func mockStreams() (stdoutBuffer *bytes.Buffer, stderrBuffer *bytes.Buffer, restore func()) {
+	origStdin, origStdout, origStderr := stdin, stdout, stderr
+	stdoutBuffer, stderrBuffer = &bytes.Buffer{}, &bytes.Buffer{}
+	stdin, stdout, stderr = strings.NewReader(""), stdoutBuffer, stderrBuffer
+	return stdoutBuffer, stderrBuffer, func() { stdin, stdout, stderr = origStdin, origStdout, origStderr }
+}
+
+func TestExecuteRun(t *testing.T) {
+	stdoutBuffer, stderrBuffer, restore := mockStreams()
+	defer restore()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	output := &bytes.Buffer{}
+	stdcopy.NewStdWriter(output, stdcopy.Stdout).Write([]byte("hello\n"))
+	stdcopy.NewStdWriter(output, stdcopy.Stderr).Write([]byte("warning\n"))
+	httpApiClient.output = output.Bytes()
+	httpApiClient.statusCode = 3
+
+	statusCode, err := dockerClient.executeRun(dockerClient.getRunCmdArgs("sha256:456", []string{"make"}))
+	util.CheckErr(err)
+
+	assert.Equal(t, 3, statusCode)
+	assert.Equal(t, []string{"create sha256:456", "attach abc", "start abc", "wait abc", "remove abc"}, httpApiClient.calls)
+	assert.Equal(t, "hello\n", stdoutBuffer.String())
+	assert.Equal(t, "warning\n", stderrBuffer.String())
+	assert.Equal(t, []string{"make"}, []string(httpApiClient.config.Cmd))
+	assert.Equal(t, "/testAppFile", httpApiClient.config.Labels["containerflight_appFile"])
+	assert.False(t, httpApiClient.config.Tty)
+}
+
+func TestExecuteRunTTY(t *testing.T) {
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: true")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+	httpApiClient.output = []byte("raw output")
+
+	statusCode, err := dockerClient.executeRun(dockerClient.getRunCmdArgs("sha256:456", []string{}))
+	util.CheckErr(err)
+
+	assert.Equal(t, 0, statusCode)
+	assert.True(t, httpApiClient.config.Tty)
+	assert.Equal(t, "raw output", stdoutBuffer.String())
+}
+
+func TestExecuteRunUnsupported(t *testing.T) {
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	appInfo.AddDockerRunArgs("--runtime", "runsc")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	// the run is refused instead of ignoring the option
+	_, err := dockerClient.executeRun(dockerClient.getRunCmdArgs("sha256:456", []string{}))
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(httpApiClient.calls))
+}
+
+func TestExecuteRunDetached(t *testing.T) {
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", "console: false")
+	appInfo.AddDockerRunArgs("--detach")
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	statusCode, err := dockerClient.executeRun(dockerClient.getRunCmdArgs("sha256:456", []string{}))
+	util.CheckErr(err)
+
+	assert.Equal(t, 0, statusCode)
+	assert.Equal(t, []string{"create sha256:456", "start abc"}, httpApiClient.calls)
+	assert.True(t, httpApiClient.hostConfig.AutoRemove)
+	assert.Equal(t, "abc\n", stdoutBuffer.String())
+}
+
+// return the names and contents of the files of a tar archive
+func readTarArchive(archive []byte) map[string]string {
+	files := map[string]string{}
+	tarReader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files
+		}
+		util.CheckErr(err)
+		content, err := ioutil.ReadAll(tarReader)
+		util.CheckErr(err)
+		files[header.Name] = string(content)
+	}
+}
+
+func TestGetBuildContext(t *testing.T) {
+	appConfigStr := "image:\n    dockerfile: |\n        RUN test"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	archive, err := ioutil.ReadAll(dockerClient.getBuildContext("/buildctx"))
+	util.CheckErr(err)
+
+	files := readTarArchive(archive)
+	assert.Equal(t, 1, len(files))
+	assert.Regexp(t, "RUN test", files[buildContextDockerfile])
+}
+
+func TestGetBuildContextWithFiles(t *testing.T) {
+	afero.WriteFile(filesystem, "/buildctx/app.sh", []byte("echo"), 0755)
+	afero.WriteFile(filesystem, "/buildctx/secret.key", []byte("key"), 0644)
+	afero.WriteFile(filesystem, "/buildctx/build/output", []byte("bin"), 0644)
+	afero.WriteFile(filesystem, "/buildctx/.dockerignore", []byte("*.key\nbuild\n"), 0644)
+	defer filesystem.RemoveAll("/buildctx")
+
+	appConfigStr := "image:\n    dockerfile: |\n        COPY app.sh /usr/local/bin"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	archive, err := ioutil.ReadAll(dockerClient.getBuildContext("/buildctx"))
+	util.CheckErr(err)
+
+	files := readTarArchive(archive)
+	assert.Equal(t, "echo", files["app.sh"])
+	assert.Contains(t, files, ".dockerignore")
+	assert.NotContains(t, files, "secret.key")
+	assert.NotContains(t, files, "build/")
+	assert.NotContains(t, files, "build/output")
+	assert.Regexp(t, "COPY app.sh", files[buildContextDockerfile])
+}
+
+func TestGetBuildContextError(t *testing.T) {
+	afero.WriteFile(filesystem, "/buildctx/.dockerignore", []byte("[\n"), 0644)
+	defer filesystem.RemoveAll("/buildctx")
+
+	appConfigStr := "image:\n    dockerfile: |\n        COPY app.sh /usr/local/bin"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+
+	// errors of the archive are returned by the stream
+	_, err := ioutil.ReadAll(dockerClient.getBuildContext("/buildctx"))
+	assert.Error(t, err)
+}
+
+func TestBuildImage(t *testing.T) {
+	stdoutBuffer, _, restore := mockStreams()
+	defer restore()
+
+	appConfigStr := "image:\n    dockerfile: |\n        RUN test"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/testAppFile", appConfigStr)
+	dockerClient := newDockerClient(appInfo)
+	httpApiClient := dockerClient.client.(*mockHttpApiClient)
+
+	err := dockerClient.buildImage("/buildctx", "containerflight_test:1.0", "hashStr")
+	util.CheckErr(err)
+
+	assert.Equal(t, []string{"build containerflight_test:1.0"}, httpApiClient.calls)
+	assert.Equal(t, buildContextDockerfile, httpApiClient.buildOptions.Dockerfile)
+	assert.Equal(t, "hashStr", httpApiClient.buildOptions.Labels["containerflight_hash"])
+	assert.Equal(t, "/testAppFile", httpApiClient.buildOptions.Labels["containerflight_appFile"])
+	assert.Contains(t, readTarArchive(httpApiClient.buildContext), buildContextDockerfile)
+	assert.Equal(t, "Successfully built 123\n", stdoutBuffer.String())
+}
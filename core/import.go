@@ -0,0 +1,438 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "github.com/go-yaml/yaml"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/tjeske/containerflight/util"
+)
+
+// app file content gathered by an import
+type importedApp struct {
+	name       string
+	base       string
+	dockerfile string
+	runArgs    []string
+}
+
+// a single (possibly multi-line) Dockerfile instruction
+type dockerfileInstruction struct {
+	keyword string
+	raw     string
+	logical string
+}
+
+var dockerfileVarRegex = regexp.MustCompile(`\$\{([[:word:]]+)\}`)
+var composeVarRegex = regexp.MustCompile(`\$\{([[:word:]]+)\}|\$([[:word:]]+)`)
+var wordCharRegex = regexp.MustCompile(`^[[:word:]]`)
+
+// ImportDockerfile converts a Dockerfile into an app file
+// The app file is printed out if no output file is given.
+func ImportDockerfile(dockerfileName string, outputFileName string) {
+	rawData, err := afero.ReadFile(filesystem, dockerfileName)
+	util.CheckErr(err)
+
+	app := importedApp{}
+	app.base, app.dockerfile = convertDockerfile(string(rawData))
+
+	writeImportedApp(app, outputFileName)
+}
+
+// ImportCompose converts a service of a compose file into an app file
+func ImportCompose(composeFileName string, serviceName string, outputFileName string) {
+	absComposeFileName, err := filepath.Abs(composeFileName)
+	util.CheckErr(err)
+
+	rawData, err := afero.ReadFile(filesystem, absComposeFileName)
+	util.CheckErr(err)
+
+	app := convertComposeService(rawData, filepath.Dir(absComposeFileName), serviceName)
+
+	writeImportedApp(app, outputFileName)
+}
+
+// print the app file or store it as an executable file
+func writeImportedApp(app importedApp, outputFileName string) {
+	appFileContent := renderImportedApp(app)
+
+	if outputFileName == "" {
+		fmt.Print(appFileContent)
+		return
+	}
+
+	if _, err := filesystem.Stat(outputFileName); err == nil {
+		log.Fatalf("ERROR: App file \"%s\" already exists!", outputFileName)
+	}
+	err := afero.WriteFile(filesystem, outputFileName, []byte(appFileContent), 0755)
+	util.CheckErr(err)
+}
+
+// split a Dockerfile into instructions, continuation lines are kept together
+func splitDockerfile(content string) []dockerfileInstruction {
+	instructions := []dockerfileInstruction{}
+
+	rawLines := []string{}
+	logicalLines := []string{}
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		rawLines = append(rawLines, line)
+
+		// comments and empty lines inside of an instruction are ignored by Docker
+		if len(logicalLines) > 0 && (trimmedLine == "" || strings.HasPrefix(trimmedLine, "#")) {
+			continue
+		}
+
+		if strings.HasSuffix(trimmedLine, "\\") {
+			logicalLines = append(logicalLines, strings.TrimSuffix(trimmedLine, "\\"))
+			continue
+		}
+		logicalLines = append(logicalLines, trimmedLine)
+
+		logical := strings.Join(logicalLines, " ")
+		keyword := ""
+		if !strings.HasPrefix(logical, "#") {
+			keyword = strings.ToUpper(strings.SplitN(logical, " ", 2)[0])
+		}
+		instructions = append(instructions, dockerfileInstruction{
+			keyword: keyword,
+			raw:     strings.Join(rawLines, "\n"),
+			logical: logical,
+		})
+		rawLines = []string{}
+		logicalLines = []string{}
+	}
+
+	// unterminated continuation at the end of the file
+	if len(rawLines) > 0 {
+		instructions = append(instructions, dockerfileInstruction{raw: strings.Join(rawLines, "\n")})
+	}
+
+	return instructions
+}
+
+// convert a Dockerfile into the base image and the remaining Dockerfile of an app file
+func convertDockerfile(content string) (base string, dockerfile string) {
+	instructions := splitDockerfile(content)
+
+	// only a single stage can be expressed by "image.base"
+	numStages := 0
+	for _, instruction := range instructions {
+		if instruction.keyword == "FROM" {
+			numStages++
+		}
+	}
+	if numStages > 1 {
+		log.Warn("multi-stage Dockerfile detected, FROM instructions are kept in the Dockerfile")
+	}
+
+	lines := []string{}
+	for _, instruction := range instructions {
+		switch instruction.keyword {
+		case "FROM":
+			if numStages == 1 {
+				if image, ok := getFromImage(instruction.logical); ok {
+					base = "docker://" + image
+					continue
+				}
+			}
+		case "RUN":
+			if aptInstall, ok := collapseAptInstall(instruction.logical); ok {
+				lines = append(lines, aptInstall)
+				continue
+			}
+		}
+		lines = append(lines, escapeDockerfileVars(instruction.raw))
+	}
+
+	dockerfile = strings.Trim(strings.Join(lines, "\n"), "\n")
+
+	return base, dockerfile
+}
+
+// return the image of a simple "FROM <image> [AS <name>]" instruction
+func getFromImage(fromInstruction string) (string, bool) {
+	fields := strings.Fields(fromInstruction)
+	if len(fields) != 2 && !(len(fields) == 4 && strings.ToUpper(fields[2]) == "AS") {
+		return "", false
+	}
+	image := fields[1]
+	if strings.HasPrefix(image, "--") || strings.Contains(image, "$") {
+		return "", false
+	}
+	return image, true
+}
+
+// collapse "apt-get update && apt-get install ..." into ${APT_INSTALL(...)}
+func collapseAptInstall(runInstruction string) (string, bool) {
+	command := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(runInstruction), strings.SplitN(runInstruction, " ", 2)[0]))
+	if strings.HasPrefix(command, "[") {
+		// exec form
+		return "", false
+	}
+
+	packages := []string{}
+	for _, part := range strings.Split(command, "&&") {
+		fields := strings.Fields(part)
+		if len(fields) > 0 && fields[0] == "DEBIAN_FRONTEND=noninteractive" {
+			fields = fields[1:]
+		}
+		partStr := strings.Join(fields, " ")
+
+		switch {
+		case partStr == "apt-get update", partStr == "apt-get -q update", partStr == "apt-get -qq update":
+		case partStr == "export DEBIAN_FRONTEND=noninteractive":
+		case partStr == "apt-get clean":
+		case partStr == "rm -rf /var/lib/apt/lists/*":
+		case len(fields) > 2 && fields[0] == "apt-get" && fields[1] == "install":
+			for _, field := range fields[2:] {
+				switch field {
+				case "-y", "--yes", "--assume-yes", "-q", "-qq", "--quiet":
+				default:
+					// other options change the semantics of ${APT_INSTALL(...)}
+					if strings.HasPrefix(field, "-") || strings.ContainsAny(field, "$`'\"") {
+						return "", false
+					}
+					packages = append(packages, field)
+				}
+			}
+		default:
+			return "", false
+		}
+	}
+
+	if len(packages) == 0 {
+		return "", false
+	}
+
+	return "${APT_INSTALL(" + strings.Join(packages, ", ") + ")}", true
+}
+
+// rewrite Dockerfile variables "${VAR}" to "$VAR" so that they are not taken for containerflight parameters
+func escapeDockerfileVars(str string) string {
+	result := ""
+	last := 0
+	for _, match := range dockerfileVarRegex.FindAllStringSubmatchIndex(str, -1) {
+		result += str[last:match[0]]
+		varName := str[match[2]:match[3]]
+		if wordCharRegex.MatchString(str[match[1]:]) {
+			log.Warnf("Dockerfile variable \"${%s}\" would be resolved as a containerflight parameter", varName)
+			result += str[match[0]:match[1]]
+		} else {
+			result += "$" + varName
+		}
+		last = match[1]
+	}
+	return result + str[last:]
+}
+
+// compose file specification (only the parts which can be mapped to an app file)
+type composeSpec struct {
+	Services map[string]composeServiceSpec
+}
+
+type composeServiceSpec struct {
+	Image       string
+	Build       interface{}
+	Volumes     []interface{}
+	Environment interface{}
+}
+
+// convert a compose service into an app file
+func convertComposeService(composeFileContent []byte, composeFileDir string, serviceName string) importedApp {
+	compose := composeSpec{}
+	err := yaml.Unmarshal(composeFileContent, &compose)
+	util.CheckErr(err)
+
+	service, ok := compose.Services[serviceName]
+	if !ok {
+		log.Fatalf("ERROR: Service \"%s\" not found in compose file!", serviceName)
+	}
+
+	app := importedApp{name: serviceName}
+
+	if service.Build != nil {
+		buildCtx, dockerfileName := getComposeBuild(service.Build)
+		if !filepath.IsAbs(buildCtx) {
+			buildCtx = filepath.Join(composeFileDir, buildCtx)
+		}
+		rawData, err := afero.ReadFile(filesystem, filepath.Join(buildCtx, dockerfileName))
+		util.CheckErr(err)
+		app.base, app.dockerfile = convertDockerfile(string(rawData))
+	} else if service.Image != "" {
+		app.base = "docker://" + service.Image
+	}
+
+	for _, volume := range service.Volumes {
+		volumeStr, ok := getComposeVolume(volume)
+		if !ok {
+			log.Warnf("unsupported volume definition %v", volume)
+			continue
+		}
+		app.runArgs = append(app.runArgs, "-v", volumeStr)
+	}
+
+	for _, env := range getComposeEnvironment(service.Environment) {
+		app.runArgs = append(app.runArgs, "-e", env)
+	}
+
+	return app
+}
+
+// return build context and Dockerfile of a compose build definition
+func getComposeBuild(build interface{}) (buildCtx string, dockerfileName string) {
+	dockerfileName = "Dockerfile"
+	switch build := build.(type) {
+	case string:
+		buildCtx = build
+	case map[interface{}]interface{}:
+		if ctx, ok := build["context"].(string); ok {
+			buildCtx = ctx
+		}
+		if dockerfile, ok := build["dockerfile"].(string); ok {
+			dockerfileName = dockerfile
+		}
+	}
+	return buildCtx, dockerfileName
+}
+
+// translate a compose volume into a "docker run -v" argument
+func getComposeVolume(volume interface{}) (string, bool) {
+	parts := []string{}
+	switch volume := volume.(type) {
+	case string:
+		parts = strings.Split(volume, ":")
+	case map[interface{}]interface{}:
+		source, _ := volume["source"].(string)
+		target, _ := volume["target"].(string)
+		if target == "" {
+			return "", false
+		}
+		if source != "" {
+			parts = append(parts, source)
+		}
+		parts = append(parts, target)
+		if readOnly, _ := volume["read_only"].(bool); readOnly {
+			parts = append(parts, "ro")
+		}
+	default:
+		return "", false
+	}
+
+	for i := range parts {
+		parts[i] = convertComposeVars(parts[i])
+	}
+
+	// relative host paths are resolved relative to the compose file
+	if len(parts) > 1 {
+		hostPath := parts[0]
+		switch {
+		case hostPath == "~" || strings.HasPrefix(hostPath, "~/"):
+			parts[0] = "${HOME}" + strings.TrimPrefix(hostPath, "~")
+		case hostPath == "." || strings.HasPrefix(hostPath, "./") || strings.HasPrefix(hostPath, "../"):
+			parts[0] = "${APP_FILE_DIR}/" + filepath.ToSlash(filepath.Clean(hostPath))
+		}
+	}
+
+	return strings.Join(parts, ":"), true
+}
+
+// translate compose environment variables (list or map) into "KEY=VALUE" strings
+func getComposeEnvironment(environment interface{}) []string {
+	envs := []string{}
+	switch environment := environment.(type) {
+	case []interface{}:
+		for _, env := range environment {
+			envs = append(envs, convertComposeVars(fmt.Sprint(env)))
+		}
+	case map[interface{}]interface{}:
+		for key, value := range environment {
+			if value == nil {
+				// pass the value of the host
+				envs = append(envs, fmt.Sprint(key))
+			} else {
+				envs = append(envs, fmt.Sprint(key)+"="+convertComposeVars(fmt.Sprint(value)))
+			}
+		}
+		sort.Strings(envs)
+	}
+	return envs
+}
+
+// translate compose variable substitution into ${ENV(...)}
+func convertComposeVars(str string) string {
+	if strings.Contains(str, "${") && !dockerfileVarRegex.MatchString(str) {
+		log.Warnf("unsupported variable substitution in \"%s\"", str)
+	}
+	return composeVarRegex.ReplaceAllStringFunc(str, func(match string) string {
+		varName := strings.Trim(match, "${}")
+		return "${ENV(" + varName + ")}"
+	})
+}
+
+// render the content of an app file
+func renderImportedApp(app importedApp) string {
+	appFileContent := getShebangLine() + "\n"
+
+	if app.name != "" {
+		appFileContent += "name: " + strconv.Quote(app.name) + "\n"
+	}
+
+	if app.base != "" || app.dockerfile != "" {
+		appFileContent += "\nimage:\n"
+		if app.base != "" {
+			appFileContent += "    base: " + app.base + "\n"
+		}
+		if app.dockerfile != "" {
+			if strings.TrimLeft(app.dockerfile, " \t") != app.dockerfile {
+				// indented first line -> explicit indentation indicator
+				appFileContent += "    dockerfile: |4\n"
+			} else {
+				appFileContent += "    dockerfile: |\n"
+			}
+			for _, line := range strings.Split(app.dockerfile, "\n") {
+				if strings.TrimSpace(line) == "" {
+					appFileContent += "\n"
+				} else {
+					appFileContent += "        " + line + "\n"
+				}
+			}
+		}
+	}
+
+	if len(app.runArgs) > 0 {
+		appFileContent += "\nruntime:\n" +
+			"    docker:\n" +
+			"        runargs: [\n"
+		for i := 0; i < len(app.runArgs); i += 2 {
+			appFileContent += "            " + strconv.Quote(app.runArgs[i]) + ", " + strconv.Quote(app.runArgs[i+1])
+			if i+2 < len(app.runArgs) {
+				appFileContent += ","
+			}
+			appFileContent += "\n"
+		}
+		appFileContent += "        ]\n"
+	}
+
+	return appFileContent
+}
@@ -0,0 +1,134 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+)
+
+func TestConvertDockerfile(t *testing.T) {
+	dockerfile := "FROM ubuntu:18.04\n" +
+		"\n" +
+		"RUN apt-get update && \\\n" +
+		"    apt-get install -y gcc \\\n" +
+		"        make && \\\n" +
+		"    rm -rf /var/lib/apt/lists/*\n" +
+		"ENV PATH=${PATH}:/opt/bin\n" +
+		"ENTRYPOINT [ \"gcc\" ]\n"
+
+	base, resultDockerfile := convertDockerfile(dockerfile)
+
+	assert.Equal(t, "docker://ubuntu:18.04", base)
+	assert.Equal(t, "${APT_INSTALL(gcc, make)}\nENV PATH=$PATH:/opt/bin\nENTRYPOINT [ \"gcc\" ]", resultDockerfile)
+}
+
+func TestConvertDockerfileMultiStage(t *testing.T) {
+	dockerfile := "FROM golang AS builder\nRUN go build\nFROM alpine\nCOPY --from=builder /app /app"
+
+	base, resultDockerfile := convertDockerfile(dockerfile)
+
+	assert.Equal(t, "", base)
+	assert.Equal(t, dockerfile, resultDockerfile)
+}
+
+func TestCollapseAptInstall(t *testing.T) {
+	aptInstall, ok := collapseAptInstall("RUN apt-get update && export DEBIAN_FRONTEND=noninteractive && apt-get install -y wget")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "${APT_INSTALL(wget)}", aptInstall)
+
+	// "--no-install-recommends" cannot be expressed by ${APT_INSTALL(...)}
+	_, ok = collapseAptInstall("RUN apt-get update && apt-get install -y --no-install-recommends wget")
+	assert.Equal(t, false, ok)
+
+	_, ok = collapseAptInstall("RUN apt-get update && make")
+	assert.Equal(t, false, ok)
+}
+
+func TestConvertComposeService(t *testing.T) {
+	compose := "version: '3'\n" +
+		"services:\n" +
+		"    web:\n" +
+		"        image: python:3.7\n" +
+		"        volumes:\n" +
+		"            - ./data:/data\n" +
+		"            - ~/.cache:/cache:ro\n" +
+		"            - named:/named\n" +
+		"        environment:\n" +
+		"            DEBUG: 1\n" +
+		"            PROXY: ${http_proxy}\n" +
+		"            PASSTHROUGH:\n"
+
+	app := convertComposeService([]byte(compose), "/compose", "web")
+
+	expRunArgs := []string{
+		"-v", "${APP_FILE_DIR}/data:/data",
+		"-v", "${HOME}/.cache:/cache:ro",
+		"-v", "named:/named",
+		"-e", "DEBUG=1",
+		"-e", "PASSTHROUGH",
+		"-e", "PROXY=${ENV(http_proxy)}",
+	}
+	assert.Equal(t, "web", app.name)
+	assert.Equal(t, "docker://python:3.7", app.base)
+	assert.Equal(t, expRunArgs, app.runArgs)
+
+	// named volumes must not become bind mounts of the imported app file
+	defer mockFilesystem()()
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/compose/web", renderImportedApp(app))
+	assert.Contains(t, appInfo.GetDockerRunArgs(), "named:/named")
+}
+
+func TestConvertComposeServiceBuild(t *testing.T) {
+	defer mockFilesystem()()
+
+	filesystem.MkdirAll("/compose/app", 0755)
+	afero.WriteFile(filesystem, "/compose/app/Dockerfile.dev", []byte("FROM alpine\nRUN make"), 0644)
+
+	compose := "services:\n" +
+		"    app:\n" +
+		"        build:\n" +
+		"            context: ./app\n" +
+		"            dockerfile: Dockerfile.dev\n"
+
+	app := convertComposeService([]byte(compose), "/compose", "app")
+
+	assert.Equal(t, "docker://alpine", app.base)
+	assert.Equal(t, "RUN make", app.dockerfile)
+}
+
+func TestRenderImportedApp(t *testing.T) {
+	defer mockFilesystem()()
+
+	app := importedApp{
+		name:       "web",
+		base:       "docker://python:3.7",
+		dockerfile: "${APT_INSTALL(gcc)}\n\nENTRYPOINT [ \"python\" ]",
+		runArgs:    []string{"-v", "${HOME}:${HOME}", "-e", "A=\"b\""},
+	}
+
+	appFileContent := renderImportedApp(app)
+
+	// the result must be a valid app file
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/importedAppFile", appFileContent)
+
+	assert.Equal(t, "web", appInfo.GetAppName())
+	assert.Regexp(t, "(?m)^FROM python:3.7$", appInfo.GetDockerfile())
+	assert.Regexp(t, "(?m)^ENTRYPOINT \\[ \"python\" \\]$", appInfo.GetDockerfile())
+	assert.Contains(t, appInfo.GetDockerRunArgs(), "A=\"b\"")
+}
@@ -0,0 +1,301 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	yaml "github.com/go-yaml/yaml"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+)
+
+// an app file which is installed as a command
+type installedApp struct {
+	Name        string
+	AppFile     string `yaml:"appFile"`
+	Launcher    string
+	DesktopFile string `yaml:"desktopFile,omitempty"`
+}
+
+// list of all installed app files
+type installManifest struct {
+	Apps []installedApp
+}
+
+// return the directory where launchers are installed
+var getBinDir = func() string {
+	homeDir, err := os.UserHomeDir()
+	util.CheckErr(err)
+	return filepath.Join(homeDir, ".local", "bin")
+}
+
+// return the directory where desktop entries are installed
+var getDesktopDir = func() string {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		homeDir, err := os.UserHomeDir()
+		util.CheckErr(err)
+		dataDir = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataDir, "applications")
+}
+
+// return the file which tracks the installed app files
+func getManifestFile() string {
+	return filepath.Join(getConfigDir(), "installed.yaml")
+}
+
+// InstallApp creates a launcher for an app file so that it can be called like any other command.
+// With desktop set a desktop entry is created additionally.
+func InstallApp(yamlAppConfigFileName string, name string, desktop bool) {
+
+	// the app file must be valid
+	appInfo := appinfo.NewAppInfo(yamlAppConfigFileName)
+	appConfigFile := appInfo.GetAppConfigFile()
+
+	if name == "" {
+		name = filepath.Base(appConfigFile)
+	}
+	if !checkInstallName(name) {
+		return
+	}
+
+	manifest := readManifest()
+	_, isInstalled := manifest.find(name)
+
+	launcher := filepath.Join(getBinDir(), name)
+	if _, err := filesystem.Stat(launcher); err == nil && !isInstalled {
+		log.Fatalf("ERROR: \"%s\" already exists and was not installed by containerflight!", launcher)
+	}
+
+	app := installedApp{Name: name, AppFile: appConfigFile, Launcher: launcher}
+	writeLauncher(app)
+
+	if desktop {
+		if !appInfo.IsGuiApp() {
+			log.Warnf("\"%s\" is not a gui app", appConfigFile)
+		}
+		app.DesktopFile = filepath.Join(getDesktopDir(), "containerflight-"+name+".desktop")
+		writeDesktopEntry(app, appInfo)
+	} else if oldApp, ok := manifest.find(name); ok && oldApp.DesktopFile != "" {
+		removeFile(oldApp.DesktopFile)
+	}
+
+	manifest.put(app)
+	writeManifest(manifest)
+
+	fmt.Println("installed \"" + name + "\" to " + launcher)
+}
+
+// UninstallApp removes the launcher of an installed app file
+func UninstallApp(name string) {
+	if !checkInstallName(name) {
+		return
+	}
+
+	manifest := readManifest()
+	app, ok := manifest.find(name)
+	if !ok {
+		log.Fatalf("ERROR: \"%s\" is not installed!", name)
+	}
+
+	removeFile(app.Launcher)
+	if app.DesktopFile != "" {
+		removeFile(app.DesktopFile)
+	}
+
+	manifest.remove(name)
+	writeManifest(manifest)
+}
+
+// the name of an installed app is the file name of its launcher in the bin directory
+func checkInstallName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		logFatalf("ERROR: Invalid name \"%s\", the name must not be a path!", name)
+		return false
+	}
+	return true
+}
+
+// ListInstalledApps prints out all installed app files
+func ListInstalledApps() {
+	manifest := readManifest()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tAPP FILE\tLAUNCHER")
+	for _, app := range manifest.Apps {
+		appFile := app.AppFile
+		if _, err := filesystem.Stat(appFile); err != nil {
+			appFile += " (missing)"
+		}
+		fmt.Fprintln(writer, app.Name+"\t"+appFile+"\t"+app.Launcher)
+	}
+	writer.Flush()
+}
+
+// UpdateInstalledApps refreshes the launchers and images of all installed app files
+func UpdateInstalledApps() {
+	manifest := readManifest()
+	for _, app := range manifest.Apps {
+		if _, err := filesystem.Stat(app.AppFile); err != nil {
+			log.Warnf("skip \"%s\": %v", app.Name, err)
+			continue
+		}
+
+		appInfo := appinfo.NewAppInfo(app.AppFile)
+
+		// the containerflight executable could have been moved
+		writeLauncher(app)
+		if app.DesktopFile != "" {
+			writeDesktopEntry(app, appInfo)
+		}
+
+		// build image if the app file has changed
+		dockerClient := NewDockerClient(appInfo)
+		dockerClient.getImageID()
+
+		fmt.Println("updated \"" + app.Name + "\"")
+	}
+}
+
+// create a shell script which runs the app file
+func writeLauncher(app installedApp) {
+	launcherContent := "#!/bin/sh\n" +
+		"# generated by containerflight, use \"containerflight uninstall " + app.Name + "\" to remove it\n" +
+		"exec " + shellQuote(getExecutable()) + " run " + shellQuote(app.AppFile) + " \"$@\"\n"
+
+	err := filesystem.MkdirAll(filepath.Dir(app.Launcher), 0755)
+	util.CheckErr(err)
+	err = afero.WriteFile(filesystem, app.Launcher, []byte(launcherContent), 0755)
+	util.CheckErr(err)
+}
+
+// create a freedesktop.org desktop entry which runs the app file without console
+func writeDesktopEntry(app installedApp, appInfo *appinfo.AppInfo) {
+	exec := []string{getExecutable(), "run", "--no-console", app.AppFile}
+	for i := range exec {
+		exec[i] = desktopExecQuote(exec[i])
+	}
+
+	desktopEntry := "[Desktop Entry]\n" +
+		"Type=Application\n" +
+		"Name=" + desktopEscape(appInfo.GetAppName()) + "\n"
+	if description := appInfo.GetAppDescription(); description != "" {
+		desktopEntry += "Comment=" + desktopEscape(description) + "\n"
+	}
+	if icon := appInfo.GetAppIcon(); icon != "" {
+		desktopEntry += "Icon=" + desktopEscape(icon) + "\n"
+	}
+	desktopEntry += "Exec=" + strings.Join(exec, " ") + "\n" +
+		"Terminal=false\n" +
+		"X-Containerflight-AppFile=" + desktopEscape(app.AppFile) + "\n"
+
+	err := filesystem.MkdirAll(filepath.Dir(app.DesktopFile), 0755)
+	util.CheckErr(err)
+	err = afero.WriteFile(filesystem, app.DesktopFile, []byte(desktopEntry), 0644)
+	util.CheckErr(err)
+}
+
+// escape a string value of a desktop entry
+func desktopEscape(str string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(str)
+}
+
+// quote an argument of the "Exec" key of a desktop entry
+func desktopExecQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`") {
+		return desktopEscape(arg)
+	}
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`).Replace(arg)
+	return desktopEscape(`"` + arg + `"`)
+}
+
+// remove a file, missing files are ignored
+func removeFile(fileName string) {
+	err := filesystem.Remove(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		util.CheckErr(err)
+	}
+}
+
+// quote a string for a POSIX shell
+func shellQuote(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+}
+
+// read the list of installed app files
+func readManifest() *installManifest {
+	manifest := &installManifest{}
+	rawData, err := afero.ReadFile(filesystem, getManifestFile())
+	if os.IsNotExist(err) {
+		return manifest
+	}
+	util.CheckErr(err)
+
+	err = yaml.Unmarshal(rawData, manifest)
+	util.CheckErrMsg(err, "Cannot read "+getManifestFile())
+
+	return manifest
+}
+
+// store the list of installed app files
+func writeManifest(manifest *installManifest) {
+	rawData, err := yaml.Marshal(manifest)
+	util.CheckErr(err)
+
+	err = filesystem.MkdirAll(getConfigDir(), 0755)
+	util.CheckErr(err)
+	err = afero.WriteFile(filesystem, getManifestFile(), rawData, 0644)
+	util.CheckErr(err)
+}
+
+// find an installed app file by name
+func (manifest *installManifest) find(name string) (installedApp, bool) {
+	for _, app := range manifest.Apps {
+		if app.Name == name {
+			return app, true
+		}
+	}
+	return installedApp{}, false
+}
+
+// add or replace an installed app file
+func (manifest *installManifest) put(app installedApp) {
+	for i := range manifest.Apps {
+		if manifest.Apps[i].Name == app.Name {
+			manifest.Apps[i] = app
+			return
+		}
+	}
+	manifest.Apps = append(manifest.Apps, app)
+}
+
+// remove an installed app file
+func (manifest *installManifest) remove(name string) {
+	for i := range manifest.Apps {
+		if manifest.Apps[i].Name == name {
+			manifest.Apps = append(manifest.Apps[:i], manifest.Apps[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+)
+
+func TestWriteLauncher(t *testing.T) {
+	defer mockFilesystem()()
+
+	writeLauncher(installedApp{Name: "myapp", AppFile: "/apps/myapp", Launcher: "/bin/myapp"})
+
+	rawData, err := afero.ReadFile(filesystem, "/bin/myapp")
+	util.CheckErr(err)
+
+	expLauncher := "#!/bin/sh\n" +
+		"# generated by containerflight, use \"containerflight uninstall myapp\" to remove it\n" +
+		"exec '/usr/local/bin/containerflight' run '/apps/myapp' \"$@\"\n"
+	assert.Equal(t, expLauncher, string(rawData))
+}
+
+func TestWriteDesktopEntry(t *testing.T) {
+	defer mockFilesystem()()
+
+	appConfigStr := "name: My App\ndescription: some description\nicon: /icons/app.png\ngui: true"
+	appInfo := appinfo.NewFakeAppInfo(&filesystem, "/apps/my app", appConfigStr)
+
+	app := installedApp{Name: "myapp", AppFile: "/apps/my app", Launcher: "/bin/myapp", DesktopFile: "/desktop/containerflight-myapp.desktop"}
+	writeDesktopEntry(app, appInfo)
+
+	rawData, err := afero.ReadFile(filesystem, "/desktop/containerflight-myapp.desktop")
+	util.CheckErr(err)
+
+	expDesktopEntry := "[Desktop Entry]\n" +
+		"Type=Application\n" +
+		"Name=My App\n" +
+		"Comment=some description\n" +
+		"Icon=/icons/app.png\n" +
+		"Exec=/usr/local/bin/containerflight run --no-console \"/apps/my app\"\n" +
+		"Terminal=false\n" +
+		"X-Containerflight-AppFile=/apps/my app\n"
+	assert.Equal(t, expDesktopEntry, string(rawData))
+}
+
+func TestDesktopExecQuote(t *testing.T) {
+	assert.Equal(t, "/apps/app", desktopExecQuote("/apps/app"))
+	assert.Equal(t, `"/apps/100%% \\$app"`, desktopExecQuote("/apps/100% $app"))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'/my apps/it'\''s'`, shellQuote("/my apps/it's"))
+}
+
+func TestCheckInstallName(t *testing.T) {
+	assert.True(t, checkInstallName("my-app.v2"))
+
+	for _, name := range []string{"", ".", "..", "../x", "/tmp/x", `..\x`} {
+		testForLogFatal(t, func() {
+			assert.False(t, checkInstallName(name))
+		})
+	}
+
+	// nothing outside of the bin directory is removed
+	testForLogFatal(t, func() {
+		UninstallApp("../x")
+	})
+}
+
+func TestManifest(t *testing.T) {
+	defer mockFilesystem()()
+
+	manifest := readManifest()
+	manifest.put(installedApp{Name: "app1", AppFile: "/apps/app1", Launcher: "/bin/app1"})
+	manifest.put(installedApp{Name: "app2", AppFile: "/apps/app2", Launcher: "/bin/app2"})
+	manifest.put(installedApp{Name: "app1", AppFile: "/apps/app1new", Launcher: "/bin/app1"})
+	writeManifest(manifest)
+
+	manifest = readManifest()
+	assert.Equal(t, 2, len(manifest.Apps))
+	app, ok := manifest.find("app1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "/apps/app1new", app.AppFile)
+
+	manifest.remove("app1")
+	_, ok = manifest.find("app1")
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 1, len(manifest.Apps))
+}
+
+func TestUninstallApp(t *testing.T) {
+	defer mockFilesystem()()
+
+	app := installedApp{Name: "uninstallme", AppFile: "/apps/uninstallme", Launcher: "/bin/uninstallme", DesktopFile: "/desktop/uninstallme.desktop"}
+	writeLauncher(app)
+	afero.WriteFile(filesystem, app.DesktopFile, []byte("[Desktop Entry]"), 0644)
+	manifest := readManifest()
+	manifest.put(app)
+	writeManifest(manifest)
+
+	UninstallApp("uninstallme")
+
+	_, err := filesystem.Stat("/bin/uninstallme")
+	assert.Error(t, err)
+	_, err = filesystem.Stat("/desktop/uninstallme.desktop")
+	assert.Error(t, err)
+	_, ok := readManifest().find("uninstallme")
+	assert.Equal(t, false, ok)
+}
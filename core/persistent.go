@@ -0,0 +1,168 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjeske/containerflight/util"
+	"golang.org/x/net/context"
+)
+
+// main process of a persistent container: exit after the idle timeout if no invocation is running, the
+// process ID file of an invocation is removed if its process has ended without removing it (an empty
+// file is being written)
+const keepaliveScript = `idle=0
+while [ "$idle" -lt "$1" ]; do
+    sleep 1
+    idle=$((idle + 1))
+    for pidFile in ` + execPIDFilePrefix + `*; do
+        [ -e "$pidFile" ] || continue
+        pid=$(cat "$pidFile" 2>/dev/null)
+        if [ -z "$pid" ] || [ -d "/proc/$pid" ]; then idle=0; else rm -f "$pidFile"; fi
+    done
+done`
+
+// runPersistent executes the app in the long-lived container of the app file and the current working
+// directory, the container is started if it is not running
+func (dc *DockerClient) runPersistent(args []string, options RunOptions) {
+	imageID := dc.getImageID()
+	optionsHash := options.getPersistentOptionsHash()
+
+	container, ok := dc.findPersistentContainer()
+	if ok && container.Labels["containerflight_runOptions"] != optionsHash {
+		logFatalf("ERROR: The persistent container of \"%s\" has been started with other options, -v, --runarg, --cpus "+
+			"and --memory cannot be changed while it is running (use \"containerflight stop\")!", dc.appInfo.GetAppConfigFile())
+		return
+	}
+	if !ok {
+		dc.appInfo.AddDockerRunArgs(options.getPersistentRunArgs()...)
+		err := dc.startPersistentContainer(imageID, optionsHash)
+		container, ok = dc.findPersistentContainer()
+		if !ok {
+			logFatalf("ERROR: Persistent container of \"%s\" is not running: %v", dc.appInfo.GetAppConfigFile(), err)
+			return
+		}
+	}
+
+	image, _, err := dc.client.ImageInspectWithRaw(context.Background(), imageID)
+	util.CheckErr(err)
+
+	statusCode, err := dc.executeExec(container.ID, dc.getPersistentExecConfig(image, args, options))
+	util.CheckErr(err)
+	if statusCode != 0 {
+		os.Exit(statusCode)
+	}
+}
+
+// get the docker run arguments of the command line options which are fixed when the persistent
+// container is created, the other options are applied to each invocation
+func (options RunOptions) getPersistentRunArgs() []string {
+	runArgs := []string{}
+	for _, volume := range options.Volumes {
+		runArgs = append(runArgs, "-v", volume)
+	}
+	return append(runArgs, options.RunArgs...)
+}
+
+// get a hash of the command line options which are fixed when the persistent container is created
+func (options RunOptions) getPersistentOptionsHash() string {
+	fixedOptions := append(options.getPersistentRunArgs(), "--cpus", options.Cpus, "--memory", options.Memory)
+	hash := sha256.Sum256([]byte(strings.Join(fixedOptions, "\x00")))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// start the persistent container which keeps running until the idle timeout elapses, an error does not
+// matter if a parallel invocation has started the container in the meantime
+func (dc *DockerClient) startPersistentContainer(imageID string, optionsHash string) error {
+	idleTimeout := int(dc.appInfo.GetIdleTimeout().Seconds())
+
+	dc.appInfo.AddDockerRunArgs(
+		"--detach",
+		"--name", dc.getPersistentContainerName(),
+		"--label", "containerflight_persistent=true",
+		"--label", "containerflight_workingDir="+getUnixWorkingDir(),
+		"--label", "containerflight_runOptions="+optionsHash,
+		"--entrypoint", "/bin/sh",
+	)
+	runArgs := dc.getRunCmdArgs(imageID, []string{"-c", keepaliveScript, "keepalive", strconv.Itoa(idleTimeout)})
+
+	_, err := dc.executeRun(runArgs)
+	if err != nil {
+		log.Debug("cannot start persistent container: ", err)
+	}
+	return err
+}
+
+// findPersistentContainer returns the running persistent container of the app file and the current
+// working directory
+func (dc *DockerClient) findPersistentContainer() (types.Container, bool) {
+	options := types.ContainerListOptions{Filters: filters.NewArgs(
+		filters.Arg("label", "containerflight_appFile="+dc.appInfo.GetAppConfigFile()),
+		filters.Arg("label", "containerflight_hash="+dc.getDockerContainerHash()),
+		filters.Arg("label", "containerflight_persistent=true"),
+		filters.Arg("label", "containerflight_workingDir="+getUnixWorkingDir()),
+	)}
+	containers, err := dc.client.ContainerList(context.Background(), options)
+	util.CheckErr(err)
+	if len(containers) == 0 {
+		return types.Container{}, false
+	}
+	return containers[0], true
+}
+
+// get a container name which is unique for the app image and the working directory so that
+// parallel invocations cannot start several containers
+func (dc *DockerClient) getPersistentContainerName() string {
+	workingDirHash := sha256.Sum256([]byte(getUnixWorkingDir()))
+	return "containerflight_" + dc.getDockerContainerHash()[:12] + "_" + hex.EncodeToString(workingDirHash[:])[:12]
+}
+
+// get the Engine API configuration of an exec which runs the entrypoint of the image with the given args,
+// environment variables, working directory and entrypoint of the command line apply to the invocation
+func (dc *DockerClient) getPersistentExecConfig(image types.ImageInspect, args []string, options RunOptions) types.ExecConfig {
+	config := dc.newExecConfig()
+	config.Env = getRunEnv(options.Env)
+	config.WorkingDir = getUnixWorkingDir()
+	if options.Workdir != "" {
+		config.WorkingDir = options.Workdir
+	}
+	// like "docker run --entrypoint" the command of the image is not used with another entrypoint
+	if options.Entrypoint != "" {
+		config.Cmd = append([]string{options.Entrypoint}, args...)
+		return config
+	}
+	config.Cmd = []string{}
+	if image.Config != nil {
+		config.Cmd = append(config.Cmd, image.Config.Entrypoint...)
+		if len(args) == 0 {
+			args = image.Config.Cmd
+		}
+	}
+	config.Cmd = append(config.Cmd, args...)
+	return config
+}
+
+// return the working directory as it is mounted into the container
+func getUnixWorkingDir() string {
+	return util.GetUnixFilePath(util.GetWorkingDir())
+}
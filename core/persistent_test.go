@@ -0,0 +1,88 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"golang.org/x/net/context"
+)
+
+// The benchmarks compare the latency of a call in a new container with a call in a running container,
+// they need a Docker daemon and the image $CONTAINERFLIGHT_BENCH_IMAGE (default: busybox) which is not
+// pulled:
+//
+//     go test -run '^$' -bench Call ./core
+
+// create a client of the Docker daemon, the benchmark is skipped if the daemon or the image is missing
+func newBenchmarkDockerClient(b *testing.B) (*DockerClient, string) {
+	apiClient, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		b.Skip("no Docker client: ", err)
+	}
+	ctx := context.Background()
+	if _, err := apiClient.Ping(ctx); err != nil {
+		b.Skip("no Docker daemon: ", err)
+	}
+	apiClient.NegotiateAPIVersion(ctx)
+
+	image := os.Getenv("CONTAINERFLIGHT_BENCH_IMAGE")
+	if image == "" {
+		image = "busybox"
+	}
+	if _, _, err := apiClient.ImageInspectWithRaw(ctx, image); err != nil {
+		b.Skip("no image: ", err)
+	}
+	return &DockerClient{client: apiClient}, image
+}
+
+func BenchmarkCallEphemeral(b *testing.B) {
+	dockerClient, image := newBenchmarkDockerClient(b)
+	_, _, restore := mockStreams()
+	defer restore()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		statusCode, err := dockerClient.executeRun([]string{"--rm", image, "true"})
+		if err != nil || statusCode != 0 {
+			b.Fatal("call failed: ", statusCode, err)
+		}
+	}
+}
+
+func BenchmarkCallPersistent(b *testing.B) {
+	dockerClient, image := newBenchmarkDockerClient(b)
+	_, _, restore := mockStreams()
+	defer restore()
+
+	name := "containerflight_bench_" + newRunID()[:12]
+	_, err := dockerClient.executeRun([]string{"--rm", "--detach", "--name", name, image, "sleep", "3600"})
+	if err != nil {
+		b.Fatal("cannot start container: ", err)
+	}
+	defer dockerClient.client.ContainerRemove(context.Background(), name, types.ContainerRemoveOptions{Force: true})
+
+	config := types.ExecConfig{AttachStdout: true, AttachStderr: true, Cmd: []string{"true"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		statusCode, err := dockerClient.executeExec(name, config)
+		if err != nil || statusCode != 0 {
+			b.Fatal("call failed: ", statusCode, err)
+		}
+	}
+}
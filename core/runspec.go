@@ -0,0 +1,480 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+	"github.com/spf13/afero"
+	"github.com/spf13/pflag"
+)
+
+// runSpec is the Engine API representation of "docker run" arguments
+type runSpec struct {
+	name       string
+	detach     bool
+	remove     bool
+	config     *container.Config
+	hostConfig *container.HostConfig
+}
+
+// "docker run" options which can be translated into a runSpec, other options are refused
+type runFlags struct {
+	remove, init, detach, tty, interactive, readOnly, privileged, sigProxy bool
+
+	attach, volumes, mounts, env, envFiles, labels, capAdd, capDrop, tmpfs, securityOpts, publish, addHosts, ulimits, devices, groupAdd, dns []string
+
+	hostname, workdir, user, entrypoint, name, network, ipc, cpus, memory, shmSize, gpus string
+
+	stopTimeout int
+	pidsLimit   int64
+}
+
+// parse "docker run" arguments ([OPTIONS] IMAGE [COMMAND] [ARG...]), an error is returned for unsupported options
+func parseRunCmdArgs(runCmdArgs []string) (*runSpec, error) {
+	opts := runFlags{}
+	flags := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	flags.SetInterspersed(false)
+	flags.Usage = func() {}
+	flags.SetOutput(nopWriter{})
+
+	flags.BoolVar(&opts.remove, "rm", false, "")
+	flags.BoolVar(&opts.init, "init", false, "")
+	flags.BoolVarP(&opts.detach, "detach", "d", false, "")
+	flags.BoolVarP(&opts.tty, "tty", "t", false, "")
+	flags.BoolVarP(&opts.interactive, "interactive", "i", false, "")
+	flags.BoolVar(&opts.readOnly, "read-only", false, "")
+	flags.BoolVar(&opts.privileged, "privileged", false, "")
+	flags.BoolVar(&opts.sigProxy, "sig-proxy", true, "")
+
+	flags.StringArrayVarP(&opts.attach, "attach", "a", nil, "")
+	flags.StringArrayVarP(&opts.volumes, "volume", "v", nil, "")
+	flags.StringArrayVar(&opts.mounts, "mount", nil, "")
+	flags.StringArrayVarP(&opts.env, "env", "e", nil, "")
+	flags.StringArrayVar(&opts.envFiles, "env-file", nil, "")
+	flags.StringArrayVarP(&opts.labels, "label", "l", nil, "")
+	flags.StringArrayVar(&opts.capAdd, "cap-add", nil, "")
+	flags.StringArrayVar(&opts.capDrop, "cap-drop", nil, "")
+	flags.StringArrayVar(&opts.tmpfs, "tmpfs", nil, "")
+	flags.StringArrayVar(&opts.securityOpts, "security-opt", nil, "")
+	flags.StringArrayVarP(&opts.publish, "publish", "p", nil, "")
+	flags.StringArrayVar(&opts.addHosts, "add-host", nil, "")
+	flags.StringArrayVar(&opts.ulimits, "ulimit", nil, "")
+	flags.StringArrayVar(&opts.devices, "device", nil, "")
+	flags.StringArrayVar(&opts.groupAdd, "group-add", nil, "")
+	flags.StringArrayVar(&opts.dns, "dns", nil, "")
+
+	flags.StringVarP(&opts.hostname, "hostname", "h", "", "")
+	flags.StringVarP(&opts.workdir, "workdir", "w", "", "")
+	flags.StringVarP(&opts.user, "user", "u", "", "")
+	flags.StringVar(&opts.entrypoint, "entrypoint", "", "")
+	flags.StringVar(&opts.name, "name", "", "")
+	flags.StringVar(&opts.network, "network", "", "")
+	flags.StringVar(&opts.network, "net", "", "")
+	flags.StringVar(&opts.ipc, "ipc", "", "")
+	flags.StringVar(&opts.cpus, "cpus", "", "")
+	flags.StringVarP(&opts.memory, "memory", "m", "", "")
+	flags.StringVar(&opts.shmSize, "shm-size", "", "")
+	flags.StringVar(&opts.gpus, "gpus", "", "")
+	flags.IntVar(&opts.stopTimeout, "stop-timeout", 0, "")
+	flags.Int64Var(&opts.pidsLimit, "pids-limit", 0, "")
+
+	// "docker run" arguments are trimmed by the docker cli as well
+	trimmedArgs := make([]string, len(runCmdArgs))
+	for i, arg := range runCmdArgs {
+		trimmedArgs[i] = strings.TrimSpace(arg)
+	}
+	if err := flags.Parse(trimmedArgs); err != nil {
+		return nil, err
+	}
+	if flags.NArg() == 0 {
+		return nil, errors.New("no image given")
+	}
+
+	return opts.toRunSpec(flags)
+}
+
+// translate the parsed options into an Engine API container configuration
+func (opts *runFlags) toRunSpec(flags *pflag.FlagSet) (*runSpec, error) {
+	env, err := readRunEnvFiles(opts.envFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &container.Config{
+		Image:      flags.Arg(0),
+		Cmd:        strslice.StrSlice(flags.Args()[1:]),
+		Hostname:   opts.hostname,
+		WorkingDir: opts.workdir,
+		User:       opts.user,
+		Env:        getRunEnv(append(env, opts.env...)),
+		Labels:     map[string]string{},
+		Volumes:    map[string]struct{}{},
+		Tty:        opts.tty,
+		OpenStdin:  opts.interactive,
+	}
+	if !opts.detach {
+		if err := opts.setAttach(config); err != nil {
+			return nil, err
+		}
+	}
+	if flags.Changed("entrypoint") {
+		config.Entrypoint = strslice.StrSlice{opts.entrypoint}
+		if opts.entrypoint == "" {
+			config.Entrypoint = strslice.StrSlice{""}
+		}
+	}
+	if flags.Changed("stop-timeout") {
+		config.StopTimeout = &opts.stopTimeout
+	}
+	for _, label := range opts.labels {
+		keyValue := strings.SplitN(label, "=", 2)
+		config.Labels[keyValue[0]] = ""
+		if len(keyValue) == 2 {
+			config.Labels[keyValue[0]] = keyValue[1]
+		}
+	}
+
+	hostConfig := &container.HostConfig{
+		// a detached container is removed by the daemon, otherwise containerflight waits for the exit
+		// code and removes the container afterwards
+		AutoRemove:     opts.remove && opts.detach,
+		NetworkMode:    container.NetworkMode(opts.network),
+		IpcMode:        container.IpcMode(opts.ipc),
+		CapAdd:         strslice.StrSlice(opts.capAdd),
+		CapDrop:        strslice.StrSlice(opts.capDrop),
+		ReadonlyRootfs: opts.readOnly,
+		Privileged:     opts.privileged,
+		ExtraHosts:     opts.addHosts,
+		GroupAdd:       opts.groupAdd,
+		DNS:            opts.dns,
+		Tmpfs:          map[string]string{},
+	}
+	if flags.Changed("init") {
+		hostConfig.Init = &opts.init
+	}
+
+	for _, volume := range opts.volumes {
+		if strings.Contains(volume, ":") {
+			hostConfig.Binds = append(hostConfig.Binds, volume)
+		} else {
+			config.Volumes[volume] = struct{}{}
+		}
+	}
+
+	for _, mountSpec := range opts.mounts {
+		runMount, err := getRunMount(mountSpec)
+		if err != nil {
+			return nil, err
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, runMount)
+	}
+
+	for _, tmpfs := range opts.tmpfs {
+		pathOptions := strings.SplitN(tmpfs, ":", 2)
+		hostConfig.Tmpfs[pathOptions[0]] = ""
+		if len(pathOptions) == 2 {
+			hostConfig.Tmpfs[pathOptions[0]] = pathOptions[1]
+		}
+	}
+
+	securityOpts, err := getRunSecurityOpts(opts.securityOpts)
+	if err != nil {
+		return nil, err
+	}
+	hostConfig.SecurityOpt = securityOpts
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(opts.publish)
+	if err != nil {
+		return nil, err
+	}
+	config.ExposedPorts = exposedPorts
+	hostConfig.PortBindings = portBindings
+
+	if err := opts.setResources(flags, &hostConfig.Resources); err != nil {
+		return nil, err
+	}
+	if opts.shmSize != "" {
+		if hostConfig.ShmSize, err = units.RAMInBytes(opts.shmSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return &runSpec{name: opts.name, detach: opts.detach, remove: opts.remove, config: config, hostConfig: hostConfig}, nil
+}
+
+// select the streams which are attached, stdout and stderr by default
+func (opts *runFlags) setAttach(config *container.Config) error {
+	config.AttachStdin = opts.interactive
+	config.AttachStdout = len(opts.attach) == 0
+	config.AttachStderr = len(opts.attach) == 0
+	for _, stream := range opts.attach {
+		switch strings.ToLower(stream) {
+		case "stdin":
+			config.AttachStdin = true
+		case "stdout":
+			config.AttachStdout = true
+		case "stderr":
+			config.AttachStderr = true
+		default:
+			return fmt.Errorf("invalid stream \"%s\" of --attach", stream)
+		}
+	}
+	if !config.AttachStdout && !config.AttachStderr {
+		return errors.New("a container without output is not waited for")
+	}
+	config.StdinOnce = config.OpenStdin && config.AttachStdin
+	return nil
+}
+
+// translate the resource limits
+func (opts *runFlags) setResources(flags *pflag.FlagSet, resources *container.Resources) error {
+	if opts.cpus != "" {
+		cpus, err := strconv.ParseFloat(opts.cpus, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpus \"%s\": %v", opts.cpus, err)
+		}
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+	if opts.memory != "" {
+		memory, err := units.RAMInBytes(opts.memory)
+		if err != nil {
+			return err
+		}
+		resources.Memory = memory
+	}
+	if flags.Changed("pids-limit") {
+		resources.PidsLimit = &opts.pidsLimit
+	}
+	for _, ulimit := range opts.ulimits {
+		parsedUlimit, err := units.ParseUlimit(ulimit)
+		if err != nil {
+			return err
+		}
+		resources.Ulimits = append(resources.Ulimits, parsedUlimit)
+	}
+	for _, device := range opts.devices {
+		resources.Devices = append(resources.Devices, getRunDevice(device))
+	}
+	if opts.gpus != "" {
+		deviceRequest, err := getRunGpus(opts.gpus)
+		if err != nil {
+			return err
+		}
+		resources.DeviceRequests = append(resources.DeviceRequests, deviceRequest)
+	}
+	return nil
+}
+
+// environment variables without value are taken from the host (and skipped if they are not set)
+func getRunEnv(envs []string) []string {
+	runEnv := []string{}
+	for _, env := range envs {
+		if strings.Contains(env, "=") {
+			runEnv = append(runEnv, env)
+		} else if value, ok := os.LookupEnv(env); ok {
+			runEnv = append(runEnv, env+"="+value)
+		}
+	}
+	return runEnv
+}
+
+// read the environment variables of env files, lines without value are taken from the host like "-e"
+func readRunEnvFiles(envFiles []string) ([]string, error) {
+	env := []string{}
+	for _, envFile := range envFiles {
+		content, err := afero.ReadFile(filesystem, envFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read env file: %v", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimLeftFunc(strings.TrimRight(line, "\r"), unicode.IsSpace)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				env = append(env, line)
+			}
+		}
+	}
+	return env, nil
+}
+
+// parse a mount "type=bind|volume|tmpfs,source=...,target=...[,readonly][,...]", a volume is mounted
+// by default
+func getRunMount(mountSpec string) (mount.Mount, error) {
+	fields, err := csv.NewReader(strings.NewReader(mountSpec)).Read()
+	if err != nil {
+		return mount.Mount{}, fmt.Errorf("invalid mount \"%s\": %v", mountSpec, err)
+	}
+
+	runMount := mount.Mount{Type: mount.TypeVolume}
+	for _, field := range fields {
+		keyValue := strings.SplitN(field, "=", 2)
+		key, value := strings.ToLower(keyValue[0]), ""
+		if len(keyValue) == 2 {
+			value = keyValue[1]
+		}
+
+		// boolean options can be given without value
+		enabled := len(keyValue) == 1
+		if key == "readonly" || key == "ro" || key == "volume-nocopy" {
+			if !enabled {
+				if enabled, err = strconv.ParseBool(value); err != nil {
+					return mount.Mount{}, fmt.Errorf("invalid value of \"%s\" in mount \"%s\"", key, mountSpec)
+				}
+			}
+		}
+
+		switch key {
+		case "type":
+			runMount.Type = mount.Type(strings.ToLower(value))
+		case "source", "src":
+			runMount.Source = value
+		case "target", "destination", "dst":
+			runMount.Target = value
+		case "readonly", "ro":
+			runMount.ReadOnly = enabled
+		case "consistency":
+			runMount.Consistency = mount.Consistency(strings.ToLower(value))
+		case "bind-propagation":
+			runMount.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(strings.ToLower(value))}
+		case "volume-nocopy":
+			runMount.VolumeOptions = &mount.VolumeOptions{NoCopy: enabled}
+		case "tmpfs-size":
+			size, err := units.RAMInBytes(value)
+			if err != nil {
+				return mount.Mount{}, fmt.Errorf("invalid tmpfs-size in mount \"%s\": %v", mountSpec, err)
+			}
+			if runMount.TmpfsOptions == nil {
+				runMount.TmpfsOptions = &mount.TmpfsOptions{}
+			}
+			runMount.TmpfsOptions.SizeBytes = size
+		case "tmpfs-mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return mount.Mount{}, fmt.Errorf("invalid tmpfs-mode in mount \"%s\": %v", mountSpec, err)
+			}
+			if runMount.TmpfsOptions == nil {
+				runMount.TmpfsOptions = &mount.TmpfsOptions{}
+			}
+			runMount.TmpfsOptions.Mode = os.FileMode(mode)
+		default:
+			return mount.Mount{}, fmt.Errorf("unsupported option \"%s\" in mount \"%s\"", key, mountSpec)
+		}
+	}
+	if runMount.Target == "" {
+		return mount.Mount{}, fmt.Errorf("no target in mount \"%s\"", mountSpec)
+	}
+	return runMount, nil
+}
+
+// parse a GPU request "all", "<count>" or "[count=...][,device=...][,driver=...][,capabilities=...]",
+// the capability "gpu" is requested by default
+func getRunGpus(gpus string) (container.DeviceRequest, error) {
+	deviceRequest := container.DeviceRequest{Capabilities: [][]string{{"gpu"}}}
+	fields, err := csv.NewReader(strings.NewReader(gpus)).Read()
+	if err != nil {
+		return deviceRequest, fmt.Errorf("invalid gpus \"%s\": %v", gpus, err)
+	}
+
+	capabilities := []string{}
+	for _, field := range fields {
+		keyValue := strings.SplitN(field, "=", 2)
+		key, value := keyValue[0], keyValue[0]
+		if len(keyValue) == 2 {
+			value = keyValue[1]
+		} else {
+			key = "count"
+		}
+
+		switch key {
+		case "count":
+			if value == "all" {
+				deviceRequest.Count = -1
+			} else if deviceRequest.Count, err = strconv.Atoi(value); err != nil {
+				return deviceRequest, fmt.Errorf("invalid count of gpus \"%s\"", gpus)
+			}
+		case "device":
+			deviceRequest.DeviceIDs = strings.Split(value, ",")
+		case "driver":
+			deviceRequest.Driver = value
+		case "capabilities":
+			capabilities = append(capabilities, value)
+		default:
+			return deviceRequest, fmt.Errorf("unsupported option \"%s\" of gpus \"%s\"", key, gpus)
+		}
+	}
+	if len(capabilities) > 0 {
+		deviceRequest.Capabilities = [][]string{capabilities}
+	}
+	if deviceRequest.Count != 0 && len(deviceRequest.DeviceIDs) > 0 {
+		return deviceRequest, fmt.Errorf("count and device of gpus \"%s\" cannot be combined", gpus)
+	}
+	return deviceRequest, nil
+}
+
+// the daemon expects the content of a seccomp profile instead of its file name
+func getRunSecurityOpts(securityOpts []string) ([]string, error) {
+	runSecurityOpts := []string{}
+	for _, securityOpt := range securityOpts {
+		keyValue := strings.SplitN(securityOpt, "=", 2)
+		if len(keyValue) == 2 && keyValue[0] == "seccomp" && keyValue[1] != "unconfined" {
+			profile, err := afero.ReadFile(filesystem, keyValue[1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot read seccomp profile: %v", err)
+			}
+			securityOpt = "seccomp=" + string(profile)
+		}
+		runSecurityOpts = append(runSecurityOpts, securityOpt)
+	}
+	return runSecurityOpts, nil
+}
+
+// parse a device mapping "host[:container][:permissions]"
+func getRunDevice(device string) container.DeviceMapping {
+	parts := strings.Split(device, ":")
+	deviceMapping := container.DeviceMapping{
+		PathOnHost:        parts[0],
+		PathInContainer:   parts[0],
+		CgroupPermissions: "rwm",
+	}
+	if len(parts) > 1 {
+		if len(parts) == 2 && strings.Trim(parts[1], "rwm") == "" {
+			deviceMapping.CgroupPermissions = parts[1]
+		} else {
+			deviceMapping.PathInContainer = parts[1]
+		}
+	}
+	if len(parts) > 2 {
+		deviceMapping.CgroupPermissions = parts[2]
+	}
+	return deviceMapping
+}
+
+// discard the usage output of the flag parser
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
@@ -18,9 +18,42 @@ import (
 	"fmt"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/tjeske/containerflight/appinfo"
 )
 
+// apply a hardened security default to all apps
+var strictSecurity = false
+
+// build args of the command line for all apps which are built
+var buildArgs = []string{}
+
+// SetStrictSecurity enables the hardened security default for all apps which are run
+func SetStrictSecurity(enabled bool) {
+	strictSecurity = enabled
+}
+
+// SetBuildArgs overrides the build args of all apps which are built ("NAME=VALUE" or "NAME")
+func SetBuildArgs(args []string) {
+	buildArgs = args
+}
+
+// load an app file which is going to be built
+func newBuildAppInfo(yamlAppConfigFileName string) *appinfo.AppInfo {
+	appInfo := appinfo.NewAppInfo(yamlAppConfigFileName)
+	appInfo.OverrideBuildArgs(buildArgs)
+	return appInfo
+}
+
+// load an app file which is going to be run, its image is built if needed
+func newRunAppInfo(yamlAppConfigFileName string) *appinfo.AppInfo {
+	appInfo := newBuildAppInfo(yamlAppConfigFileName)
+	if strictSecurity {
+		appInfo.EnableStrictSecurity()
+	}
+	return appInfo
+}
+
 // PrintDockerfile loads an app file and dump the processed dockerfile
 func PrintDockerfile(yamlAppConfigFileName string) {
 
@@ -33,11 +66,12 @@ func PrintDockerfile(yamlAppConfigFileName string) {
 // PrintDockerRunArgs show the resulting "docker run" arguments
 func PrintDockerRunArgs(yamlAppConfigFileName string) {
 
-	appInfo := appinfo.NewAppInfo(yamlAppConfigFileName)
+	appInfo := newRunAppInfo(yamlAppConfigFileName)
 	dockerClient := NewDockerClient(appInfo)
 
 	imageID := dockerClient.getImageID()
 	dockerRunCmdArgs := dockerClient.getRunCmdArgs(imageID, []string{})
+	appInfo.Cleanup()
 
 	fmt.Println("\"docker run\" will be called with the following arguments:\n" + strings.Join(dockerRunCmdArgs, " "))
 }
@@ -45,7 +79,7 @@ func PrintDockerRunArgs(yamlAppConfigFileName string) {
 // Build creates an app container image.
 func Build(yamlAppConfigFileName string) {
 
-	appInfo := appinfo.NewAppInfo(yamlAppConfigFileName)
+	appInfo := newBuildAppInfo(yamlAppConfigFileName)
 	dockerClient := NewDockerClient(appInfo)
 
 	AppFileDir := appInfo.GetAppFileDir()
@@ -57,11 +91,113 @@ func Build(yamlAppConfigFileName string) {
 	dockerClient.build(AppFileDir, containerLabel, hashStr)
 }
 
+// RunOptions contains command line options which modify how an app is run
+type RunOptions struct {
+	NoConsole     bool
+	TTY           bool
+	NoTTY         bool
+	Interactive   bool
+	NoInteractive bool
+	Detach        bool
+	Cpus          string
+	Memory        string
+	Env           []string
+	Volumes       []string
+	RunArgs       []string
+	Entrypoint    string
+	Workdir       string
+}
+
+// get the docker run arguments of the command line options
+func (options RunOptions) getDockerRunArgs() []string {
+	runArgs := []string{}
+	if options.Detach {
+		runArgs = append(runArgs, "--detach")
+	}
+	for _, env := range options.Env {
+		runArgs = append(runArgs, "-e", env)
+	}
+	for _, volume := range options.Volumes {
+		runArgs = append(runArgs, "-v", volume)
+	}
+	runArgs = append(runArgs, options.RunArgs...)
+	if options.Entrypoint != "" {
+		runArgs = append(runArgs, "--entrypoint", options.Entrypoint)
+	}
+	if options.Workdir != "" {
+		runArgs = append(runArgs, "-w", options.Workdir)
+	}
+	return runArgs
+}
+
+// apply the explicit stdin/TTY options
+func (options RunOptions) applyConsoleOptions(appInfo *appinfo.AppInfo) {
+	if options.TTY && options.NoTTY {
+		log.Fatal("ERROR: --tty and --no-tty cannot be used together!")
+	}
+	if options.Interactive && options.NoInteractive {
+		log.Fatal("ERROR: --interactive and --no-interactive cannot be used together!")
+	}
+	if options.TTY || options.NoTTY {
+		appInfo.SetTTY(options.TTY)
+	}
+	if options.Interactive || options.NoInteractive {
+		appInfo.SetInteractive(options.Interactive)
+	}
+}
+
 // Run starts an app in a container.
 // If the container does not exists it is built upfront.
-func Run(yamlAppConfigFileName string, args []string) {
+func Run(yamlAppConfigFileName string, args []string, options RunOptions) {
+
+	appInfo := newRunAppInfo(yamlAppConfigFileName)
+	if options.NoConsole {
+		appInfo.DisableConsole()
+	}
+	options.applyConsoleOptions(appInfo)
+	appInfo.OverrideResources(options.Cpus, options.Memory)
+	dockerClient := NewDockerClient(appInfo)
 
-	appInfo := appinfo.NewAppInfo(yamlAppConfigFileName)
+	if appInfo.IsPersistent() && !options.Detach && dockerClient.supportsPersistentMode() {
+		dockerClient.runPersistent(args, options)
+		return
+	}
+	appInfo.AddDockerRunArgs(options.getDockerRunArgs()...)
+	dockerClient.run(args)
+}
+
+// ShellOptions contains command line options of the debug shell
+type ShellOptions struct {
+	Root  bool
+	Shell string
+}
+
+// start bash if it is available in the image, otherwise sh
+const defaultShellCmd = "if command -v bash > /dev/null 2>&1; then exec bash; else exec sh; fi"
+
+// get the docker run arguments and the command of the debug shell
+func (options ShellOptions) getDockerRunArgs() (runArgs []string, args []string) {
+	if options.Shell != "" {
+		runArgs = []string{"--entrypoint", options.Shell}
+		args = []string{}
+	} else {
+		runArgs = []string{"--entrypoint", "/bin/sh"}
+		args = []string{"-c", defaultShellCmd}
+	}
+	if options.Root {
+		runArgs = append(runArgs, "-u", "root")
+	}
+	return runArgs, args
+}
+
+// Shell starts an interactive shell in the container of an app instead of the app itself.
+// Mounts, user and environment are the same as for a normal run.
+func Shell(yamlAppConfigFileName string, options ShellOptions) {
+
+	appInfo := newRunAppInfo(yamlAppConfigFileName)
+	appInfo.EnableConsole()
+	runArgs, args := options.getDockerRunArgs()
+	appInfo.AddDockerRunArgs(runArgs...)
 	dockerClient := NewDockerClient(appInfo)
 
 	dockerClient.run(args)
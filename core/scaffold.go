@@ -0,0 +1,197 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/blang/semver"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+	"github.com/tjeske/containerflight/util"
+	"github.com/tjeske/containerflight/version"
+)
+
+// DefaultTemplate is used by "containerflight init" if no template is given
+const DefaultTemplate = "cli"
+
+// app file templates which are part of the containerflight binary
+var builtinTemplates = map[string]string{
+	"cli": `compatibility: "{{.Compatibility}}"
+name: {{printf "%q" .Name}}
+version: 0.1
+description: {{printf "%q" .Name}}
+
+image:
+    base: docker://ubuntu:18.04
+    dockerfile: |
+        ${APT_INSTALL(bash)}
+
+        ENTRYPOINT [ "/bin/bash" ]
+
+runtime:
+    docker:
+        runargs: [
+            "-v", "${HOME}:${HOME}"
+        ]
+`,
+	"python": `compatibility: "{{.Compatibility}}"
+name: {{printf "%q" .Name}}
+version: 0.1
+description: {{printf "%q" .Name}}
+
+image:
+    base: docker://python:3.8-slim
+    dockerfile: |
+        RUN pip install --no-cache-dir --upgrade pip
+
+        ENTRYPOINT [ "python" ]
+
+runtime:
+    docker:
+        runargs: [
+            "-v", "${HOME}:${HOME}"
+        ]
+`,
+	"java": `compatibility: "{{.Compatibility}}"
+name: {{printf "%q" .Name}}
+version: 0.1
+description: {{printf "%q" .Name}}
+
+image:
+    base: docker://openjdk:11-jre-slim
+    dockerfile: |
+        ENTRYPOINT [ "java" ]
+
+runtime:
+    docker:
+        runargs: [
+            "-v", "${HOME}:${HOME}"
+        ]
+`,
+	"gui": `compatibility: "{{.Compatibility}}"
+name: {{printf "%q" .Name}}
+version: 0.1
+description: {{printf "%q" .Name}}
+
+console: false
+gui: true
+
+image:
+    base: docker://ubuntu:18.04
+    dockerfile: |
+        ${APT_INSTALL(x11-apps)}
+
+        ENTRYPOINT [ "/usr/bin/xeyes" ]
+
+runtime:
+    docker:
+        runargs: [
+            "-v", "${HOME}:${HOME}"
+        ]
+`,
+}
+
+// values which can be used in an app file template
+type templateData struct {
+	Name          string
+	Compatibility string
+}
+
+// return the path of the current containerflight executable
+var getExecutable = func() string {
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		log.Warn("cannot determine containerflight executable: ", err)
+		executable = "/usr/local/bin/containerflight"
+	}
+	return executable
+}
+
+// return the shebang line which runs an app file with the current containerflight executable
+func getShebangLine() string {
+	return "#!" + getExecutable() + " run"
+}
+
+// return the directory of the containerflight user configuration
+var getConfigDir = func() string {
+	configDir, err := os.UserConfigDir()
+	util.CheckErr(err)
+	return filepath.Join(configDir, "containerflight")
+}
+
+// InitAppFile creates a new executable app file based on a template
+func InitAppFile(appConfigFileName string, templateName string) {
+	if _, err := filesystem.Stat(appConfigFileName); err == nil {
+		log.Fatalf("ERROR: App file \"%s\" already exists!", appConfigFileName)
+	}
+
+	appFileContent := renderTemplate(templateName, filepath.Base(appConfigFileName))
+
+	err := afero.WriteFile(filesystem, appConfigFileName, []byte(appFileContent), 0755)
+	util.CheckErr(err)
+}
+
+// render an app file template, templates in the user configuration directory take precedence
+func renderTemplate(templateName string, appName string) string {
+	templateStr, ok := builtinTemplates[templateName]
+
+	userTemplateFile := filepath.Join(getConfigDir(), "templates", templateName)
+	if rawData, err := afero.ReadFile(filesystem, userTemplateFile); err == nil {
+		log.Debug("use template ", userTemplateFile)
+		templateStr, ok = string(rawData), true
+	}
+
+	if !ok {
+		templateNames := []string{}
+		for name := range builtinTemplates {
+			templateNames = append(templateNames, name)
+		}
+		sort.Strings(templateNames)
+		log.Fatalf("ERROR: Unknown template \"%s\" (available: %s or a file in %s)!",
+			templateName, strings.Join(templateNames, ", "), filepath.Dir(userTemplateFile))
+	}
+
+	tmpl, err := template.New(templateName).Parse(templateStr)
+	util.CheckErrMsg(err, "Cannot parse template \""+templateName+"\"")
+
+	data := templateData{
+		Name:          appName,
+		Compatibility: getCompatibilityRange(version.ContainerFlightVersion()),
+	}
+	var appFileContent bytes.Buffer
+	err = tmpl.Execute(&appFileContent, data)
+	util.CheckErr(err)
+
+	return getShebangLine() + "\n" + appFileContent.String()
+}
+
+// return the range of containerflight versions which are compatible to the given version
+func getCompatibilityRange(cfVersion semver.Version) string {
+	upperBound := semver.Version{Major: cfVersion.Major + 1}
+	if cfVersion.Major == 0 {
+		// major version zero: anything may change with a minor version
+		upperBound = semver.Version{Major: 0, Minor: cfVersion.Minor + 1}
+	}
+	return ">=" + cfVersion.String() + " <" + upperBound.String()
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjeske/containerflight/appinfo"
+	"github.com/tjeske/containerflight/util"
+)
+
+func TestGetCompatibilityRange(t *testing.T) {
+	assert.Equal(t, ">=0.3.1 <0.4.0", getCompatibilityRange(semver.MustParse("0.3.1")))
+	assert.Equal(t, ">=1.2.0 <2.0.0", getCompatibilityRange(semver.MustParse("1.2.0")))
+}
+
+func TestInitAppFile(t *testing.T) {
+	defer mockFilesystem()()
+
+	for templateName := range builtinTemplates {
+		appConfigFile := "/init/" + templateName
+		InitAppFile(appConfigFile, templateName)
+
+		fi, err := filesystem.Stat(appConfigFile)
+		util.CheckErr(err)
+		assert.Equal(t, "-rwxr-xr-x", fi.Mode().String())
+
+		rawData, err := afero.ReadFile(filesystem, appConfigFile)
+		util.CheckErr(err)
+		assert.Regexp(t, "^#!/usr/local/bin/containerflight run\n", string(rawData))
+
+		// the result must be a valid app file
+		appInfo := appinfo.NewFakeAppInfo(&filesystem, "/init/"+templateName+"Check", string(rawData))
+		assert.Equal(t, templateName, appInfo.GetAppName())
+	}
+}
+
+func TestInitAppFileUserTemplate(t *testing.T) {
+	defer mockFilesystem()()
+
+	afero.WriteFile(filesystem, "/config/templates/custom", []byte("name: custom {{.Name}}"), 0644)
+
+	InitAppFile("/init/myapp", "custom")
+
+	rawData, err := afero.ReadFile(filesystem, "/init/myapp")
+	util.CheckErr(err)
+	assert.Equal(t, "#!/usr/local/bin/containerflight run\nname: custom myapp", string(rawData))
+}
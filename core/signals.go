@@ -0,0 +1,203 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/term"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjeske/containerflight/util"
+	"golang.org/x/net/context"
+)
+
+// "mock connectors" for unit-tesing
+var getWinsize = func() (*term.Winsize, error) {
+	return term.GetWinsize(os.Stdout.Fd())
+}
+
+// signalProxy forwards the signals which containerflight receives to a container
+type signalProxy struct {
+	target  signalTarget
+	tty     bool
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+// signalTarget receives the forwarded signals
+type signalTarget interface {
+	// send a signal
+	kill(sig syscall.Signal) error
+	// adapt the TTY to the size of the terminal
+	resize() error
+}
+
+// create a signal proxy, a window size change only resizes a TTY
+func newSignalProxy(target signalTarget, tty bool) *signalProxy {
+	return &signalProxy{
+		target:  target,
+		tty:     tty,
+		signals: make(chan os.Signal, 16),
+		done:    make(chan struct{}),
+	}
+}
+
+// return a random ID which identifies the container of a run
+func newRunID() string {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	util.CheckErr(err)
+	return hex.EncodeToString(randomBytes)
+}
+
+// start receiving signals, containerflight is not terminated by them anymore
+func (proxy *signalProxy) start() {
+	signal.Notify(proxy.signals, proxiedSignals...)
+	go func() {
+		for sig := range proxy.signals {
+			proxy.forward(sig)
+		}
+		close(proxy.done)
+	}()
+}
+
+// stop receiving signals
+func (proxy *signalProxy) stop() {
+	signal.Stop(proxy.signals)
+	close(proxy.signals)
+	<-proxy.done
+}
+
+// forward a signal to the target, a window size change resizes its TTY
+func (proxy *signalProxy) forward(sig os.Signal) {
+	var err error
+	if isResizeSignal(sig) {
+		if !proxy.tty {
+			return
+		}
+		err = proxy.target.resize()
+	} else {
+		log.Debugf("forward signal \"%v\"", sig)
+		err = proxy.target.kill(sig.(syscall.Signal))
+	}
+	if err != nil {
+		log.Warnf("cannot forward signal \"%v\": %v", sig, err)
+	}
+}
+
+// runTarget is the container of a run, it is found by the run ID since it is created after the
+// proxy has been started
+type runTarget struct {
+	client dockerHttpApiClient
+	runID  string
+}
+
+func (target runTarget) kill(sig syscall.Signal) error {
+	containerID, err := target.findContainer()
+	if err != nil {
+		return err
+	}
+	return containerTarget{target.client, containerID}.kill(sig)
+}
+
+func (target runTarget) resize() error {
+	containerID, err := target.findContainer()
+	if err != nil {
+		return err
+	}
+	return resizeContainer(target.client, containerID)
+}
+
+// find the container of the run
+func (target runTarget) findContainer() (string, error) {
+	options := types.ContainerListOptions{Filters: filters.NewArgs(filters.Arg("label", "containerflight_run="+target.runID))}
+	containers, err := target.client.ContainerList(context.Background(), options)
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", errors.New("container is not running")
+	}
+	return containers[0].ID, nil
+}
+
+// containerTarget is a running container
+type containerTarget struct {
+	client      dockerHttpApiClient
+	containerID string
+}
+
+func (target containerTarget) kill(sig syscall.Signal) error {
+	log.Debugf("send signal \"%v\" to container %s", sig, target.containerID)
+	return target.client.ContainerKill(context.Background(), target.containerID, strconv.Itoa(int(sig)))
+}
+
+func (target containerTarget) resize() error {
+	return resizeContainer(target.client, target.containerID)
+}
+
+// execTarget is the process of an exec, signals are sent by the kill command of the container since
+// the Engine API cannot signal an exec
+type execTarget struct {
+	client      dockerHttpApiClient
+	containerID string
+	execID      string
+	pidFile     string
+}
+
+func (target execTarget) kill(sig syscall.Signal) error {
+	log.Debugf("send signal \"%v\" to exec %s", sig, target.execID)
+	return execDetached(target.client, target.containerID, []string{"/bin/sh", "-c", killScript, target.pidFile, strconv.Itoa(int(sig))})
+}
+
+func (target execTarget) resize() error {
+	return resizeExec(target.client, target.execID)
+}
+
+// adapt the TTY of a container to the size of the terminal
+func resizeContainer(client dockerHttpApiClient, containerID string) error {
+	options, ok := getResizeOptions()
+	if !ok {
+		return nil
+	}
+	return client.ContainerResize(context.Background(), containerID, options)
+}
+
+// adapt the TTY of an exec process to the size of the terminal
+func resizeExec(client dockerHttpApiClient, execID string) error {
+	options, ok := getResizeOptions()
+	if !ok {
+		return nil
+	}
+	return client.ContainerExecResize(context.Background(), execID, options)
+}
+
+// get the size of the terminal
+func getResizeOptions() (types.ResizeOptions, bool) {
+	winsize, err := getWinsize()
+	if err != nil {
+		log.Debug("cannot get terminal size: ", err)
+		return types.ResizeOptions{}, false
+	}
+	return types.ResizeOptions{Height: uint(winsize.Height), Width: uint(winsize.Width)}, true
+}
@@ -0,0 +1,103 @@
+// Copyright © 2018 Tobias Jeske
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/term"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeRuntime() *mockHttpApiClient {
+	client := newMockHttpApiClient()
+	client.containers = []types.Container{
+		{ID: "aaa111", Labels: map[string]string{"containerflight_run": "run1"}},
+		{ID: "bbb222", Labels: map[string]string{"containerflight_run": "run2"}},
+	}
+	return client
+}
+
+func TestSignalProxyForward(t *testing.T) {
+	client := newFakeRuntime()
+	proxy := newSignalProxy(runTarget{client, "run2"}, false)
+
+	proxy.forward(syscall.SIGINT)
+	proxy.forward(syscall.SIGTERM)
+	proxy.forward(syscall.SIGHUP)
+
+	// no TTY which could be resized
+	proxy.forward(syscall.SIGWINCH)
+
+	assert.Equal(t, []string{"kill bbb222 2", "kill bbb222 15", "kill bbb222 1"}, client.calls)
+}
+
+func TestSignalProxyResize(t *testing.T) {
+	origGetWinsize := getWinsize
+	defer func() { getWinsize = origGetWinsize }()
+	getWinsize = func() (*term.Winsize, error) {
+		return &term.Winsize{Height: 24, Width: 80}, nil
+	}
+
+	client := newFakeRuntime()
+	proxy := newSignalProxy(runTarget{client, "run1"}, true)
+	proxy.forward(syscall.SIGWINCH)
+
+	assert.Equal(t, []string{"resize aaa111 80x24"}, client.calls)
+}
+
+func TestSignalProxyContainerNotRunning(t *testing.T) {
+	client := newFakeRuntime()
+	proxy := newSignalProxy(runTarget{client, "unknown"}, false)
+	proxy.forward(syscall.SIGINT)
+
+	assert.Equal(t, 0, len(client.calls))
+}
+
+func TestSignalProxyExec(t *testing.T) {
+	origGetWinsize := getWinsize
+	defer func() { getWinsize = origGetWinsize }()
+	getWinsize = func() (*term.Winsize, error) {
+		return &term.Winsize{Height: 24, Width: 80}, nil
+	}
+
+	client := newFakeRuntime()
+	proxy := newSignalProxy(execTarget{client, "aaa111", "exec0", "/tmp/pid"}, true)
+	proxy.forward(syscall.SIGINT)
+	proxy.forward(syscall.SIGWINCH)
+
+	// the process of the exec is killed within the container
+	assert.Equal(t, []string{"exec aaa111", "start exec1", "resize exec0 80x24"}, client.calls)
+	assert.Equal(t, [][]string{{"/bin/sh", "-c", killScript, "/tmp/pid", "2"}}, client.execCmds)
+}
+
+func TestSignalProxyReceive(t *testing.T) {
+	client := newFakeRuntime()
+	proxy := newSignalProxy(runTarget{client, "run1"}, false)
+
+	proxy.start()
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	// give the runtime some time to deliver the signal, stop() waits until it has been forwarded
+	time.Sleep(200 * time.Millisecond)
+	proxy.stop()
+
+	assert.Equal(t, []string{"kill aaa111 1"}, client.calls)
+}